@@ -1,9 +1,12 @@
 package ads1115
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/antongulenko/tank/ft260"
+	"github.com/antongulenko/tank/smbus"
 )
 
 // TODO Missing: switching to high-speed mode
@@ -108,23 +111,27 @@ const (
 )
 
 func WriteRegister(bus ft260.I2cBus, i2cAddr byte, register byte, val uint16) error {
-	return bus.I2cWrite(i2cAddr, register, byte(val>>8), byte(val))
+	return smbus.New(bus).WithByteOrder(binary.BigEndian).WriteWordData(i2cAddr, register, val)
+}
+
+// Returns the bytes sent by WriteRegister, e.g. to write the register as step of an I2C transaction
+func WriteRegisterBytes(i2cAddr byte, register byte, val uint16) []byte {
+	return smbus.New(nil).WithByteOrder(binary.BigEndian).WordDataBytes(i2cAddr, register, val)
 }
 
 func ReadRegister(bus ft260.I2cBus, i2cAddr byte, register byte) (int16, error) {
-	v, err := bus.I2cGet(i2cAddr, register, 2)
-	if err == nil && len(v) != 2 {
-		err = fmt.Errorf("ADS1115 read len %v (need 2 byte)", len(v))
-	}
-	if err != nil {
-		return 0, err
-	}
-	return parseRegisterBytes(v), nil
+	v, err := smbus.New(bus).WithByteOrder(binary.BigEndian).ReadWordData(i2cAddr, register)
+	return int16(v), err
 }
 
 func ReadRegisterDirectly(bus ft260.I2cBus, i2cAddr byte) (int16, error) {
+	return ReadRegisterDirectlyContext(context.Background(), bus, i2cAddr)
+}
+
+// Reads the register selected by the last write, e.g. the conversion register
+func ReadRegisterDirectlyContext(ctx context.Context, bus ft260.I2cBus, i2cAddr byte) (int16, error) {
 	v := make([]byte, 2)
-	err := bus.I2cRead(i2cAddr, v)
+	err := ft260.ContextBus(bus).I2cReadContext(ctx, i2cAddr, v)
 	if err == nil && len(v) != 2 {
 		err = fmt.Errorf("ADS1115 read len %v (need 2 byte)", len(v))
 	}
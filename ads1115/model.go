@@ -0,0 +1,140 @@
+package ads1115
+
+import "math"
+
+const (
+	DEFAULT_CONFIG    = uint16(0x8583)
+	DEFAULT_LO_THRESH = uint16(0x8000)
+	DEFAULT_HI_THRESH = uint16(0x7FFF)
+)
+
+// Model is a software model of the ADS1115 registers. It implements the I2cSlave interface of the ft260.Simulator.
+// Writes of one byte only set the pointer register, writes of three bytes also write the selected register.
+// Reads return the register selected by the pointer, MSB first.
+// Conversions complete immediately: in continuous mode, every read of the conversion register converts the current
+// input voltage. In single-shot mode, writing CONFIG_OS to the config register starts one conversion.
+type Model struct {
+	Config     uint16
+	LoThresh   uint16
+	HiThresh   uint16
+	Conversion int16
+	Pointer    byte
+
+	// Returns the differential input voltage for the given input multiplexer configuration (CONFIG_MUX_*)
+	Voltage func(mux uint16) float64
+}
+
+func NewModel(voltage func(mux uint16) float64) *Model {
+	m := &Model{Voltage: voltage}
+	m.Reset()
+	return m
+}
+
+// Reset restores the power-on register values
+func (m *Model) Reset() {
+	m.Config = DEFAULT_CONFIG
+	m.LoThresh = DEFAULT_LO_THRESH
+	m.HiThresh = DEFAULT_HI_THRESH
+	m.Conversion = 0
+	m.Pointer = REG_CONVERSION
+}
+
+func (m *Model) I2cSlaveWrite(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	m.Pointer = data[0] & 0x03
+	if len(data) >= 3 {
+		m.writeRegister(m.Pointer, uint16(data[1])<<8|uint16(data[2]))
+	}
+	return nil
+}
+
+func (m *Model) I2cSlaveRead(data []byte) error {
+	val := m.readRegister(m.Pointer)
+	for i := range data {
+		if i%2 == 0 {
+			data[i] = byte(val >> 8)
+		} else {
+			data[i] = byte(val)
+		}
+	}
+	return nil
+}
+
+func (m *Model) writeRegister(register byte, val uint16) {
+	switch register {
+	case REG_CONFIG:
+		m.Config = val &^ CONFIG_OS
+		if val&CONFIG_OS != 0 && m.singleShot() {
+			m.convert()
+		}
+	case REG_LO_THRESH:
+		m.LoThresh = val
+	case REG_HI_THRESH:
+		m.HiThresh = val
+	}
+}
+
+func (m *Model) readRegister(register byte) uint16 {
+	switch register {
+	case REG_CONVERSION:
+		if !m.singleShot() {
+			m.convert()
+		}
+		return uint16(m.Conversion)
+	case REG_CONFIG:
+		if m.singleShot() {
+			return m.Config | CONFIG_OS // No conversion in progress
+		}
+		return m.Config
+	case REG_LO_THRESH:
+		return m.LoThresh
+	default:
+		return m.HiThresh
+	}
+}
+
+func (m *Model) singleShot() bool {
+	return m.Config&CONFIG_MODE != 0
+}
+
+func (m *Model) convert() {
+	var voltage float64
+	if m.Voltage != nil {
+		voltage = m.Voltage(m.Config & (0x7 << 12))
+	}
+	val := math.Round(voltage / m.FullScale() * 0x7FFF)
+	m.Conversion = int16(math.Max(math.Min(val, 0x7FFF), -0x8000))
+}
+
+// Returns the input voltage range selected by the CONFIG_PGA_* bits
+func (m *Model) FullScale() float64 {
+	switch m.Config & (0x7 << 9) {
+	case CONFIG_PGA_6V:
+		return 6.144
+	case CONFIG_PGA_4V:
+		return 4.096
+	case CONFIG_PGA_2V:
+		return 2.048
+	case CONFIG_PGA_1V:
+		return 1.024
+	case CONFIG_PGA_0_5V:
+		return 0.512
+	default:
+		return 0.256
+	}
+}
+
+// Returns true if the comparator asserts the ALERT/RDY pin for the last conversion (ignoring CONFIG_COMP_POL).
+// The traditional comparator asserts above HiThresh, the window comparator also below LoThresh.
+// The comparator queue and latching are not modelled.
+func (m *Model) Alert() bool {
+	if m.Config&CONFIG_COMP_QUE_OFF == CONFIG_COMP_QUE_OFF {
+		return false
+	}
+	if m.Conversion > int16(m.HiThresh) {
+		return true
+	}
+	return m.Config&CONFIG_COMP_MODE != 0 && m.Conversion < int16(m.LoThresh)
+}
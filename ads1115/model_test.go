@@ -0,0 +1,56 @@
+package ads1115
+
+import (
+	"testing"
+
+	"github.com/antongulenko/tank/ft260"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_model(t *testing.T) {
+	a := assert.New(t)
+	voltage := 3.0
+	model := NewModel(func(mux uint16) float64 {
+		if mux == CONFIG_MUX_03 {
+			return voltage
+		}
+		return -1
+	})
+	sim := ft260.NewSimulator()
+	sim.Attach(ADDR_GND, model)
+	bus := ft260.NewFt260(sim)
+
+	config, err := ReadRegister(bus, ADDR_GND, REG_CONFIG)
+	a.NoError(err)
+	a.Equal(DEFAULT_CONFIG, uint16(config))
+	a.Equal([]byte{REG_CONFIG, 0x85, 0x83}, WriteRegisterBytes(ADDR_GND, REG_CONFIG, DEFAULT_CONFIG))
+
+	// Continuous mode, reading without pointer update
+	a.NoError(WriteRegister(bus, ADDR_GND, REG_CONFIG, CONFIG_MUX_03|CONFIG_PGA_6V|CONFIG_COMP_QUE_OFF))
+	a.NoError(bus.I2cWrite(ADDR_GND, REG_CONVERSION))
+	val, err := ReadRegisterDirectly(bus, ADDR_GND)
+	a.NoError(err)
+	a.InDelta(3.0, float64(val)*CONVERT_6V, 0.001)
+	voltage = 7
+	val, err = ReadRegisterDirectly(bus, ADDR_GND)
+	a.NoError(err)
+	a.Equal(int16(0x7FFF), val)
+
+	// Single-shot mode only converts when requested
+	a.NoError(WriteRegister(bus, ADDR_GND, REG_CONFIG, CONFIG_MUX_01|CONFIG_PGA_2V|CONFIG_MODE|CONFIG_OS))
+	val, err = ReadRegister(bus, ADDR_GND, REG_CONVERSION)
+	a.NoError(err)
+	a.Equal(int16(-16000), val)
+	config, err = ReadRegister(bus, ADDR_GND, REG_CONFIG)
+	a.NoError(err)
+	a.NotZero(uint16(config) & CONFIG_OS)
+
+	// Thresholds and comparator
+	a.NoError(WriteRegister(bus, ADDR_GND, REG_HI_THRESH, 0x1000))
+	a.NoError(WriteRegister(bus, ADDR_GND, REG_LO_THRESH, 0xF000))
+	a.NoError(WriteRegister(bus, ADDR_GND, REG_CONFIG, CONFIG_MUX_01|CONFIG_PGA_2V|CONFIG_MODE|CONFIG_OS|CONFIG_COMP_MODE))
+	thresh, err := ReadRegister(bus, ADDR_GND, REG_HI_THRESH)
+	a.NoError(err)
+	a.Equal(int16(0x1000), thresh)
+	a.True(model.Alert())
+}
@@ -0,0 +1,199 @@
+package ft260
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CaptureWrite = "write"
+	CaptureRead  = "read"
+)
+
+// HexBytes is encoded as hex string in JSON
+type HexBytes []byte
+
+func (h HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+func (h *HexBytes) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	*h = b
+	return err
+}
+
+// CaptureRecord describes one HID report exchanged with the FT260. Captures are stored as one JSON object per line.
+type CaptureRecord struct {
+	Time     time.Time `json:"time"`
+	Dir      string    `json:"dir"` // CaptureWrite or CaptureRead
+	Feature  bool      `json:"feature"`
+	ReportID byte      `json:"report"`
+	Data     HexBytes  `json:"data"`            // Payload following the report ID. Empty for read timeouts.
+	Error    string    `json:"error,omitempty"` // Transport error, if the operation failed
+}
+
+func (r *CaptureRecord) String() string {
+	return fmt.Sprintf("%v %v report %02x (feature: %v): %v", r.Time.Format("15:04:05.000000"), r.Dir, r.ReportID, r.Feature, hex.EncodeToString(r.Data))
+}
+
+// CaptureTransport records all HID reports exchanged with the wrapped Transport.
+type CaptureTransport struct {
+	Transport
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func NewCaptureTransport(transport Transport, out io.Writer) *CaptureTransport {
+	return &CaptureTransport{
+		Transport: transport,
+		enc:       json.NewEncoder(out),
+	}
+}
+
+func (c *CaptureTransport) DoWrite(b []byte, featureReport bool) (int, error) {
+	n, err := c.Transport.DoWrite(b, featureReport)
+	c.record(CaptureWrite, b, featureReport, err)
+	return n, err
+}
+
+func (c *CaptureTransport) DoRead(b []byte, featureReport bool, timeout time.Duration) (int, error) {
+	var requestedID byte
+	if featureReport && len(b) > 0 {
+		requestedID = b[0]
+	}
+	n, err := c.Transport.DoRead(b, featureReport, timeout)
+	data := b[:0]
+	if err == nil && n > 0 && n <= len(b) {
+		data = b[:n]
+	} else if featureReport {
+		data = []byte{requestedID}
+	}
+	c.record(CaptureRead, data, featureReport, err)
+	return n, err
+}
+
+func (c *CaptureTransport) record(dir string, report []byte, featureReport bool, err error) {
+	record := CaptureRecord{
+		Time:    time.Now(),
+		Dir:     dir,
+		Feature: featureReport,
+	}
+	if len(report) > 0 {
+		record.ReportID = report[0]
+		record.Data = append(HexBytes(nil), report[1:]...)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if encodeErr := c.enc.Encode(&record); encodeErr != nil {
+		log.Warnf("ft260: Failed to write capture record: %v", encodeErr)
+	}
+}
+
+// ReadCapture parses records written by CaptureTransport
+func ReadCapture(in io.Reader) ([]CaptureRecord, error) {
+	var records []CaptureRecord
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record CaptureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("Failed to parse capture record in line %v: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// ReplayTransport plays back captured HID reports. Every write must match the next captured write,
+// and every read returns the next captured read. Interrupt polling (Ft260.Interrupts) should not be used during a replay,
+// because it changes the order of reads.
+type ReplayTransport struct {
+	Records []CaptureRecord
+
+	lock sync.Mutex
+	pos  int
+}
+
+func NewReplayTransport(records []CaptureRecord) *ReplayTransport {
+	return &ReplayTransport{
+		Records: records,
+	}
+}
+
+// Remaining returns the number of records that were not replayed yet
+func (r *ReplayTransport) Remaining() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.Records) - r.pos
+}
+
+func (r *ReplayTransport) next(dir string, featureReport bool) (*CaptureRecord, error) {
+	if r.pos >= len(r.Records) {
+		return nil, fmt.Errorf("ft260 replay: no record left for %v (feature: %v)", dir, featureReport)
+	}
+	record := &r.Records[r.pos]
+	if record.Dir != dir || record.Feature != featureReport {
+		return nil, fmt.Errorf("ft260 replay: expected %v (feature: %v), but record %v is: %v", dir, featureReport, r.pos, record)
+	}
+	r.pos++
+	return record, nil
+}
+
+func (r *ReplayTransport) DoWrite(b []byte, featureReport bool) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	record, err := r.next(CaptureWrite, featureReport)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) == 0 || b[0] != record.ReportID || !bytes.Equal(b[1:], record.Data) {
+		return 0, fmt.Errorf("ft260 replay: written report %x does not match record %v: %v", b, r.pos-1, record)
+	}
+	if record.Error != "" {
+		return 0, errors.New(record.Error)
+	}
+	return len(b), nil
+}
+
+func (r *ReplayTransport) DoRead(b []byte, featureReport bool, _ time.Duration) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	record, err := r.next(CaptureRead, featureReport)
+	if err != nil {
+		return 0, err
+	}
+	if featureReport && len(b) > 0 && b[0] != record.ReportID {
+		return 0, fmt.Errorf("ft260 replay: requested feature report %02x does not match record %v: %v", b[0], r.pos-1, record)
+	}
+	if record.Error != "" {
+		return 0, errors.New(record.Error)
+	}
+	if len(record.Data) == 0 && !featureReport {
+		return 0, nil // Timeout
+	}
+	if len(b) < len(record.Data)+1 {
+		return 0, fmt.Errorf("ft260 replay: buffer of %v byte too small for record %v: %v", len(b), r.pos-1, record)
+	}
+	b[0] = record.ReportID
+	return copy(b[1:], record.Data) + 1, nil
+}
+
+func (r *ReplayTransport) Close() error {
+	return nil
+}
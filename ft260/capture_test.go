@@ -0,0 +1,49 @@
+package ft260
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_capture_replay(t *testing.T) {
+	a := assert.New(t)
+	sim := NewSimulator()
+	slave := new(RegisterSlave)
+	slave.Registers[0x10] = 0x42
+	sim.Attach(0x20, slave)
+
+	var buf bytes.Buffer
+	dev := NewFt260(NewCaptureTransport(sim, &buf))
+	var status ReportI2cStatus
+	a.NoError(dev.Read(&status))
+	a.NoError(dev.I2cWrite(0x20, 0x05, 0x01, 0x02))
+	val, err := dev.I2cGet(0x20, 0x10, 1)
+	a.NoError(err)
+	a.Equal([]byte{0x42}, val)
+	a.Error(dev.I2cWrite(0x21, 0x00))
+
+	records, err := ReadCapture(&buf)
+	a.NoError(err)
+	a.NotEmpty(records)
+	a.Equal(CaptureRead, records[0].Dir)
+	a.True(records[0].Feature)
+	a.Equal(byte(ReportID_I2CStatus), records[0].ReportID)
+
+	// Replaying the same operations yields the same results, without the simulator
+	replay := NewReplayTransport(records)
+	dev = NewFt260(replay)
+	a.NoError(dev.Read(&status))
+	a.NoError(dev.I2cWrite(0x20, 0x05, 0x01, 0x02))
+	val, err = dev.I2cGet(0x20, 0x10, 1)
+	a.NoError(err)
+	a.Equal([]byte{0x42}, val)
+	a.Error(dev.I2cWrite(0x21, 0x00))
+	a.Equal(0, replay.Remaining())
+
+	// Diverging operations are detected
+	dev = NewFt260(NewReplayTransport(records))
+	a.NoError(dev.Read(&status))
+	a.Error(dev.I2cWrite(0x20, 0x05, 0x01, 0x03))
+}
@@ -1,9 +1,10 @@
 package ft260
 
 import (
+	"context"
 	"errors"
 	"fmt"
-
+	"sync"
 	"time"
 
 	"github.com/antongulenko/hid"
@@ -80,9 +81,9 @@ func (d *Ft260Driver) OpenPath(path string) (*Ft260, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &Ft260{
-			Device: dev,
-		}, nil
+		result := NewFt260(dev)
+		result.Path = info.Path
+		return result, nil
 	}
 }
 
@@ -94,8 +95,61 @@ func OpenPath(path string) (*Ft260, error) {
 	return (&Ft260Driver{}).OpenPath(path)
 }
 
+// Transport is the raw HID report interface used by Ft260. It is implemented by *hid.Device
+// and by the in-memory Simulator.
+type Transport interface {
+	DoWrite(b []byte, featureReport bool) (int, error)
+	DoRead(b []byte, featureReport bool, timeout time.Duration) (int, error)
+	Close() error
+}
+
 type Ft260 struct {
-	*hid.Device
+	Transport
+	Path string // USB HID device path, if opened through Ft260Driver
+
+	// Protects reading input reports, which can be I2C/UART data or interrupt status reports
+	inputLock      sync.Mutex
+	pendingInput   [][]byte
+	inputWaiters   int32 // Number of readInput calls waiting for inputLock
+	interrupts     chan InterruptEvent
+	stopInterrupts chan struct{}
+}
+
+func NewFt260(transport Transport) *Ft260 {
+	return &Ft260{
+		Transport: transport,
+	}
+}
+
+// TransportError is returned when a HID report could not be transferred to or from the device,
+// which usually means that the USB connection is broken.
+type TransportError struct {
+	Operation string
+	ReportID  byte
+	Err       error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("ft260: %v HID report %02x: %v", e.Operation, e.ReportID, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// ShortReadError is returned when a HID read returned less data than expected, e.g. because the read timed out.
+// Unlike a TransportError, this does not indicate a broken USB connection.
+type ShortReadError struct {
+	ReportID byte
+	Len      int
+	Expected int
+}
+
+func (e *ShortReadError) Error() string {
+	if e.Len == 0 {
+		return fmt.Sprintf("ft260: reading HID report %02x timed out", e.ReportID)
+	}
+	return fmt.Sprintf("ft260: reading HID report %02x: wrong read len (%v instead of %v)", e.ReportID, e.Len, e.Expected)
 }
 
 type ReportIn interface {
@@ -125,6 +179,11 @@ type VariableReportID interface {
 	IsVariableReportID() bool
 }
 
+func (f *Ft260) Close() error {
+	f.StopInterrupts()
+	return f.Transport.Close()
+}
+
 func (f *Ft260) Write(input interface{}) error {
 	var data []byte
 	feature := true
@@ -145,14 +204,37 @@ func (f *Ft260) Write(input interface{}) error {
 		return fmt.Errorf("Unexpected type for writing to FT260: %T", input)
 	}
 	log.Debugf("Writing HID report (feature: %v). Data: %#v", feature, data)
-	n, err := f.Device.DoWrite(data, feature)
+	n, err := f.Transport.DoWrite(data, feature)
 	if err == nil && n != len(data) {
-		err = fmt.Errorf("ft260: wrong write len (%v instead of %v)", n, len(data))
+		err = fmt.Errorf("wrong write len (%v instead of %v)", n, len(data))
 	}
-	return err
+	if err != nil {
+		return &TransportError{Operation: "writing", ReportID: data[0], Err: err}
+	}
+	if status, ok := input.(*SetSystemStatus); ok && status.Request == SetSystemSetting_I2CReset {
+		f.dropPendingI2cInput()
+	}
+	return nil
 }
 
 func (f *Ft260) Read(report ReportIn) error {
+	return f.read(context.Background(), report)
+}
+
+// Reads the given report. The read timeout is shortened to the deadline of the context.
+func (f *Ft260) read(ctx context.Context, report ReportIn) error {
+	timeout := ReadReportTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return context.DeadlineExceeded
+	}
 	data := make([]byte, report.ReportLen()+1)
 	data[0] = report.ReportID()
 
@@ -161,11 +243,22 @@ func (f *Ft260) Read(report ReportIn) error {
 		feature = !dataReport.IsDataReport()
 	}
 	log.Debugf("Reading HID report (feature: %v). Data: %#v", feature, data)
-	n, err := f.Device.DoRead(data, feature, ReadReportTimeout)
+	var n int
+	var err error
+	if feature {
+		n, err = f.Transport.DoRead(data, feature, timeout)
+	} else {
+		n, err = f.readInput(data, timeout)
+	}
+	if err != nil {
+		return &TransportError{Operation: "reading", ReportID: report.ReportID(), Err: err}
+	}
 	if variableReport, ok := report.(VariableSizeReport); !ok || !variableReport.IsVariableSize() {
-		if err == nil && n != len(data) {
-			err = fmt.Errorf("ft260: wrong read len (%v instead of %v)", n, len(data))
+		if n != len(data) {
+			return &ShortReadError{ReportID: report.ReportID(), Len: n, Expected: len(data)}
 		}
+	} else if n < 1 {
+		return &ShortReadError{ReportID: report.ReportID(), Len: n, Expected: 1}
 	}
 	if variableReport, ok := report.(VariableReportID); !ok || !variableReport.IsVariableReportID() {
 		if err == nil && data[0] != report.ReportID() {
@@ -199,3 +292,10 @@ func _readBool(b []byte, index int, e *error) bool {
 	}
 	return false
 }
+
+func _writeBool(val bool) byte {
+	if val {
+		return 1
+	}
+	return 0
+}
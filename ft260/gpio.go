@@ -1,5 +1,10 @@
 package ft260
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	ReportID_GPIO = 0xB0 // Feature
 )
@@ -35,3 +40,196 @@ func (r *ReportGpio) Unmarshall(b []byte) error {
 	r.DirEx = b[3]
 	return nil
 }
+
+// Returns pointers to the value and direction masks containing the given pin, and the bit of the pin.
+func (r *ReportGpio) masks(pin GpioPin) (value *byte, dir *byte, bit byte) {
+	if pin < GPIOA {
+		return &r.Value, &r.Dir, 1 << pin
+	}
+	return &r.ValueEx, &r.DirEx, 1 << (pin - GPIOA)
+}
+
+func (r *ReportGpio) Get(pin GpioPin) bool {
+	value, _, bit := r.masks(pin)
+	return *value&bit != 0
+}
+
+func (r *ReportGpio) Set(pin GpioPin, high bool) {
+	value, _, bit := r.masks(pin)
+	if high {
+		*value |= bit
+	} else {
+		*value &^= bit
+	}
+}
+
+func (r *ReportGpio) IsOutput(pin GpioPin) bool {
+	_, dir, bit := r.masks(pin)
+	return *dir&bit != 0
+}
+
+func (r *ReportGpio) SetOutput(pin GpioPin, output bool) {
+	_, dir, bit := r.masks(pin)
+	if output {
+		*dir |= bit
+	} else {
+		*dir &^= bit
+	}
+}
+
+type GpioPin byte
+
+const (
+	GPIO0 = GpioPin(iota)
+	GPIO1
+	GPIO2
+	GPIO3
+	GPIO4
+	GPIO5
+	GPIOA
+	GPIOB
+	GPIOC
+	GPIOD
+	GPIOE
+	GPIOF
+	GPIOG
+	GPIOH
+
+	NumGpioPins = int(GPIOH) + 1
+)
+
+func (p GpioPin) String() string {
+	switch {
+	case p <= GPIO5:
+		return fmt.Sprintf("GPIO%v", byte(p))
+	case p <= GPIOH:
+		return "GPIO" + string(rune('A'+p-GPIOA))
+	default:
+		return fmt.Sprintf("Unknown GPIO pin %v", byte(p))
+	}
+}
+
+// ParseGpioPin parses pin names like GPIO3 or GPIOB, as returned by GpioPin.String()
+func ParseGpioPin(name string) (GpioPin, error) {
+	for pin := GPIO0; int(pin) < NumGpioPins; pin++ {
+		if strings.EqualFold(pin.String(), name) {
+			return pin, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown FT260 GPIO pin '%v'", name)
+}
+
+func (p GpioPin) validate() error {
+	if int(p) >= NumGpioPins {
+		return fmt.Errorf("Invalid FT260 GPIO pin %v", byte(p))
+	}
+	return nil
+}
+
+// GpioAlternateFunction returns a description of the function that currently claims the given pin,
+// or an empty string if the pin can be used as GPIO.
+func (s ReportSystemStatus) GpioAlternateFunction(pin GpioPin) string {
+	switch pin {
+	case GPIO0, GPIO1:
+		if s.I2CEnable {
+			return "I2C"
+		}
+	case GPIO2:
+		if s.GPIO2Function != GPIO_2_Normal {
+			return fmt.Sprintf("GPIO 2 function %02x", s.GPIO2Function)
+		}
+	case GPIO3:
+		if s.EnableWakeupInt {
+			return "wakeup/interrupt"
+		}
+	case GPIOA:
+		if s.GPIOAFunction != GPIO_A_Normal {
+			return fmt.Sprintf("GPIO A function %02x", s.GPIOAFunction)
+		}
+	case GPIOG:
+		if s.GPIOGFunction != GPIO_G_Normal {
+			return fmt.Sprintf("GPIO G function %02x", s.GPIOGFunction)
+		}
+	case GPIOC, GPIOD:
+		if s.UartMode != UartOff {
+			return "UART RXD/TXD"
+		}
+	case GPIOB, GPIOE:
+		if s.UartMode == UartRTS_CTS {
+			return "UART RTS/CTS"
+		}
+	case GPIOF, GPIOH:
+		if s.UartMode == UartDTR_DSR {
+			return "UART DTR/DSR"
+		}
+	}
+	return ""
+}
+
+func (f *Ft260) GpioCheckAvailable(pin GpioPin) error {
+	if err := pin.validate(); err != nil {
+		return err
+	}
+	var status ReportSystemStatus
+	if err := f.Read(&status); err != nil {
+		return err
+	}
+	if function := status.GpioAlternateFunction(pin); function != "" {
+		return fmt.Errorf("FT260 pin %v is used for %v", pin, function)
+	}
+	return nil
+}
+
+func (f *Ft260) GpioSetDirection(pin GpioPin, output bool) error {
+	return f.gpioModify(pin, func(gpio *ReportGpio) error {
+		gpio.SetOutput(pin, output)
+		return nil
+	})
+}
+
+// GpioSetOutput configures the pin as output with the given level. Level and direction are written together,
+// so the pin does not glitch to its previous level when switching from input to output.
+func (f *Ft260) GpioSetOutput(pin GpioPin, high bool) error {
+	return f.gpioModify(pin, func(gpio *ReportGpio) error {
+		gpio.Set(pin, high)
+		gpio.SetOutput(pin, true)
+		return nil
+	})
+}
+
+// GpioWrite sets the level of a pin. The pin must be configured as output, see GpioSetOutput.
+func (f *Ft260) GpioWrite(pin GpioPin, high bool) error {
+	return f.gpioModify(pin, func(gpio *ReportGpio) error {
+		if !gpio.IsOutput(pin) {
+			return fmt.Errorf("FT260 pin %v is not configured as output", pin)
+		}
+		gpio.Set(pin, high)
+		return nil
+	})
+}
+
+func (f *Ft260) GpioRead(pin GpioPin) (bool, error) {
+	if err := f.GpioCheckAvailable(pin); err != nil {
+		return false, err
+	}
+	var gpio ReportGpio
+	if err := f.Read(&gpio); err != nil {
+		return false, err
+	}
+	return gpio.Get(pin), nil
+}
+
+// Read-modify-write of the GPIO report
+func (f *Ft260) gpioModify(pin GpioPin, modify func(gpio *ReportGpio) error) error {
+	if err := f.GpioCheckAvailable(pin); err != nil {
+		return err
+	}
+	var gpio ReportGpio
+	if err := f.Read(&gpio); err != nil {
+		return err
+	}
+	if err := modify(&gpio); err != nil {
+		return err
+	}
+	return f.Write(&gpio)
+}
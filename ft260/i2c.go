@@ -2,6 +2,8 @@ package ft260
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -59,29 +61,58 @@ func (e *I2cError) Error() string {
 }
 
 func (d *Ft260) I2cWrite(addr byte, data ...byte) error {
-	return d.i2cWrite(addr, true, false, data)
+	return d.I2cWriteContext(context.Background(), addr, data...)
 }
 
 func (d *Ft260) I2cRead(addr byte, data []byte) error {
-	return d.i2cRead(addr, true, false, data)
+	return d.I2cReadContext(context.Background(), addr, data)
 }
 
 func (d *Ft260) I2cWriteRead(addr byte, out, in []byte) error {
-	err := d.i2cWrite(addr, false, true, out) // No STOP!
+	return d.I2cWriteReadContext(context.Background(), addr, out, in)
+}
+
+func (d *Ft260) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	return d.I2cGetContext(context.Background(), addr, registerAddr, size)
+}
+
+func (d *Ft260) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	return d.abortOnCancel(ctx, d.i2cWrite(ctx, addr, true, false, data))
+}
+
+func (d *Ft260) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
+	return d.abortOnCancel(ctx, d.i2cRead(ctx, addr, true, false, data))
+}
+
+func (d *Ft260) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
+	err := d.i2cWrite(ctx, addr, false, true, out) // No STOP!
 	if err == nil {
-		err = d.i2cRead(addr, true, false, in)
+		err = d.i2cRead(ctx, addr, true, false, in)
 	}
-	return err
+	return d.abortOnCancel(ctx, err)
 }
 
-func (d *Ft260) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+func (d *Ft260) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error) {
 	send := []byte{registerAddr}
 	receive := make([]byte, size)
-	err := d.I2cWriteRead(addr, send, receive)
+	err := d.I2cWriteReadContext(ctx, addr, send, receive)
 	return receive, err
 }
 
-func (d *Ft260) i2cWrite(addr byte, stop bool, busBusy bool, data []byte) error {
+// If the operation was aborted because the context ended, the I2C controller is reset to cancel the pending transfer
+func (d *Ft260) abortOnCancel(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		resetErr := d.Write(&SetSystemStatus{
+			Request: SetSystemSetting_I2CReset,
+		})
+		if resetErr != nil {
+			err = fmt.Errorf("%w (failed to reset I2C controller: %v)", err, resetErr)
+		}
+	}
+	return err
+}
+
+func (d *Ft260) i2cWrite(ctx context.Context, addr byte, stop bool, busBusy bool, data []byte) error {
 	payloads, conditions := i2cSplitTransaction(stop, data)
 	if len(payloads) == 0 {
 		return nil
@@ -89,9 +120,9 @@ func (d *Ft260) i2cWrite(addr byte, stop bool, busBusy bool, data []byte) error
 	for i, payload := range payloads {
 		condition := conditions[i]
 		busy := busBusy || i < len(payloads)-1
-		if err := d.i2cSingleWrite(addr, condition, busy, payload); err != nil {
+		if err := d.i2cSingleWrite(ctx, addr, condition, busy, payload); err != nil {
 			if len(payloads) > 1 {
-				err = fmt.Errorf("Error on write transaction %v out of %v: %v", i+1, len(payloads), err)
+				err = fmt.Errorf("Error on write transaction %v out of %v: %w", i+1, len(payloads), err)
 			}
 			return err
 		}
@@ -99,16 +130,18 @@ func (d *Ft260) i2cWrite(addr byte, stop bool, busBusy bool, data []byte) error
 	return nil
 }
 
-func (d *Ft260) i2cRead(addr byte, stop bool, busBusy bool, data []byte) error {
+func (d *Ft260) i2cRead(ctx context.Context, addr byte, stop bool, busBusy bool, data []byte) error {
 	payloads, conditions := i2cSplitTransaction(stop, data)
 	if len(payloads) == 0 {
 		return nil
 	}
 	for i, payload := range payloads {
 		condition := conditions[i]
-		if err := d.i2cSingleRead(addr, condition, busBusy, payload); err != nil {
+		// Chunks before the last one end without STOP, so the bus is still busy afterwards
+		busy := busBusy || i < len(payloads)-1
+		if err := d.i2cSingleRead(ctx, addr, condition, busy, payload); err != nil {
 			if len(payloads) > 1 {
-				err = fmt.Errorf("Error on read transaction %v out of %v: %v", i+1, len(payloads), err)
+				err = fmt.Errorf("Error on read transaction %v out of %v: %w", i+1, len(payloads), err)
 			}
 			return err
 		}
@@ -147,7 +180,10 @@ func i2cSplitTransaction(stop bool, data []byte) (payloads [][]byte, conditions
 	return
 }
 
-func (d *Ft260) i2cSingleWrite(addr byte, condition byte, busBusy bool, data []byte) error {
+func (d *Ft260) i2cSingleWrite(ctx context.Context, addr byte, condition byte, busBusy bool, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	op := OperationI2cWrite{
 		SlaveAddr: addr,
 		Condition: condition,
@@ -157,11 +193,14 @@ func (d *Ft260) i2cSingleWrite(addr byte, condition byte, busBusy bool, data []b
 	if err != nil {
 		return err
 	}
-	err = d.i2cWait(busBusy)
+	err = d.i2cWait(ctx, busBusy)
 	return d.extendError(err, "writing", addr, condition, data)
 }
 
-func (d *Ft260) i2cSingleRead(addr byte, condition byte, busBusy bool, data []byte) error {
+func (d *Ft260) i2cSingleRead(ctx context.Context, addr byte, condition byte, busBusy bool, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	op := OperationI2cRead{
 		SlaveAddr: addr,
 		Condition: condition,
@@ -171,14 +210,14 @@ func (d *Ft260) i2cSingleRead(addr byte, condition byte, busBusy bool, data []by
 	if err != nil {
 		return err
 	}
-	if err := d.i2cWait(busBusy); err != nil {
+	if err := d.i2cWait(ctx, busBusy); err != nil {
 		return d.extendError(err, "reading", addr, condition, data)
 	}
 
 	op2 := OperationI2cInput{
 		Data: data,
 	}
-	return d.Read(&op2)
+	return d.read(ctx, &op2)
 }
 
 func (d *Ft260) extendError(err error, operation string, addr byte, condition byte, data []byte) error {
@@ -187,7 +226,7 @@ func (d *Ft260) extendError(err error, operation string, addr byte, condition by
 		if i2cErr, ok := err.(*I2cError); ok {
 			i2cErr.OperationDescription = desc
 		} else {
-			err = fmt.Errorf("%v (%v)", err, desc)
+			err = fmt.Errorf("%w (%v)", err, desc)
 		}
 	}
 	return err
@@ -195,7 +234,7 @@ func (d *Ft260) extendError(err error, operation string, addr byte, condition by
 
 const i2c_any_error = I2C_StatusError | I2C_StatusNoSlaveAck | I2C_StatusNoDataAck | I2C_StatusArbitrationLost
 
-func (d *Ft260) i2cWait(busBusy bool) error {
+func (d *Ft260) i2cWait(ctx context.Context, busBusy bool) error {
 	start := time.Now()
 	var op ReportI2cStatus
 	expectedStatus := I2C_StatusControllerIdle
@@ -203,8 +242,11 @@ func (d *Ft260) i2cWait(busBusy bool) error {
 		expectedStatus = I2C_StatusBusBusy
 	}
 	for i := 0; i < I2cNumChecks; i++ {
-		if err := d.Read(&op); err != nil {
-			return fmt.Errorf("Failed to check I2C status while waiting for operation to complete: %v", err)
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("Aborted waiting for I2C operation to complete: %w", err)
+		}
+		if err := d.read(ctx, &op); err != nil {
+			return fmt.Errorf("Failed to check I2C status while waiting for operation to complete: %w", err)
 		}
 		s := op.BusStatus
 
@@ -237,6 +279,57 @@ type I2cBus interface {
 	I2cGet(addr byte, registerAddr byte, size int) ([]byte, error)
 }
 
+// I2cBusContext is implemented by I2C buses that can abort operations when the given context ends.
+// The returned error wraps the error of the context in that case.
+type I2cBusContext interface {
+	I2cBus
+	I2cWriteContext(ctx context.Context, addr byte, data ...byte) error
+	I2cReadContext(ctx context.Context, addr byte, data []byte) error
+	I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error
+	I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error)
+}
+
+// ContextBus returns the given bus, if it implements I2cBusContext. Otherwise, the bus is wrapped and the context
+// is only checked before every operation.
+func ContextBus(bus I2cBus) I2cBusContext {
+	if ctxBus, ok := bus.(I2cBusContext); ok {
+		return ctxBus
+	}
+	return contextCheckingBus{bus}
+}
+
+type contextCheckingBus struct {
+	I2cBus
+}
+
+func (b contextCheckingBus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.I2cWrite(addr, data...)
+}
+
+func (b contextCheckingBus) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.I2cRead(addr, data)
+}
+
+func (b contextCheckingBus) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.I2cWriteRead(addr, out, in)
+}
+
+func (b contextCheckingBus) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.I2cGet(addr, registerAddr, size)
+}
+
 type I2cScanner struct {
 }
 
@@ -261,7 +354,8 @@ func I2cScanRange(bus I2cBus, from, to byte) ([]byte, error) {
 	for addr := from; addr <= to; addr++ {
 		err := bus.I2cRead(addr, []byte{0})
 		if err != nil {
-			if i2cErr, ok := err.(*I2cError); ok {
+			var i2cErr *I2cError
+			if errors.As(err, &i2cErr) {
 				if !i2cErr.TimedOut &&
 					0 == i2cErr.BusStatus&(I2C_StatusBusBusy|I2C_StatusArbitrationLost|I2C_StatusControllerBusy) &&
 					0 != i2cErr.BusStatus&I2C_StatusError {
@@ -68,6 +68,12 @@ func (r *ReportI2cStatus) Unmarshall(b []byte) error {
 	return nil
 }
 
+func (r *ReportI2cStatus) Marshall(b []byte) error {
+	b[0] = r.BusStatus
+	b[1], b[2] = byte(r.BusSpeed), byte(r.BusSpeed>>8)
+	return nil
+}
+
 // Data of ReportID_I2CRead Interrupt Out
 type OperationI2cRead struct {
 	SlaveAddr byte   // 0..127
@@ -97,6 +103,16 @@ func (r *OperationI2cRead) Marshall(b []byte) error {
 	return nil
 }
 
+func (r *OperationI2cRead) Unmarshall(b []byte) error {
+	if len(b) < r.ReportLen() {
+		return fmt.Errorf("I2C read request too short (%v byte)", len(b))
+	}
+	r.SlaveAddr = b[0]
+	r.Condition = b[1]
+	r.Len = uint16(b[2]) + uint16(b[3])<<8
+	return nil
+}
+
 // Data of ReportID_I2CInOut Interrupt Out
 type OperationI2cWrite struct {
 	SlaveAddr byte // 0..127
@@ -110,7 +126,11 @@ func (r *OperationI2cWrite) IsDataReport() bool {
 }
 
 func (r *OperationI2cWrite) ReportID() byte {
-	return ReportID_I2CInOut + byte(len(r.Payload))/4
+	// Report 0xD0 carries up to 4 byte, every following report ID 4 byte more
+	if len(r.Payload) == 0 {
+		return ReportID_I2CInOut
+	}
+	return ReportID_I2CInOut + byte(len(r.Payload)-1)/4
 }
 
 func (r *OperationI2cWrite) ReportLen() int {
@@ -131,6 +151,16 @@ func (r *OperationI2cWrite) Marshall(b []byte) error {
 	return nil
 }
 
+func (r *OperationI2cWrite) Unmarshall(b []byte) error {
+	if len(b) < 3 || len(b) < int(b[2])+3 {
+		return fmt.Errorf("I2C write request too short (%v byte)", len(b))
+	}
+	r.SlaveAddr = b[0]
+	r.Condition = b[1]
+	r.Payload = append([]byte(nil), b[3:3+b[2]]...)
+	return nil
+}
+
 // Data of ReportID_I2CInOut Interrupt In
 type OperationI2cInput struct {
 	// 1 byte payload length
@@ -155,7 +185,7 @@ func (r *OperationI2cInput) ReportID() byte {
 }
 
 func (r *OperationI2cInput) ReportLen() int {
-	return I2CMaxPayload - 1 // Max possible report length
+	return I2CMaxPayload + 1 // Max possible report length: payload length byte and payload
 }
 
 func (r *OperationI2cInput) Unmarshall(d []byte) error {
@@ -0,0 +1,99 @@
+package ft260
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_i2c_write_report_id(t *testing.T) {
+	a := assert.New(t)
+	test := func(payloadLen int, expected byte) {
+		op := &OperationI2cWrite{Payload: make([]byte, payloadLen)}
+		a.Equal(expected, op.ReportID(), "Report ID for %v byte payload", payloadLen)
+	}
+	test(0, 0xD0)
+	test(1, 0xD0)
+	test(4, 0xD0)
+	test(5, 0xD1)
+	test(8, 0xD1)
+	test(9, 0xD2)
+	test(56, 0xDD)
+	test(57, 0xDE)
+	test(I2CMaxPayload, ReportID_I2CInOut_Max)
+}
+
+func Test_i2c_input_report_len(t *testing.T) {
+	a := assert.New(t)
+
+	// A full input report carries the payload length byte and I2CMaxPayload data bytes
+	report := make([]byte, I2CMaxPayload+1)
+	report[0] = I2CMaxPayload
+	for i := 1; i < len(report); i++ {
+		report[i] = byte(i)
+	}
+	op := &OperationI2cInput{Data: make([]byte, I2CMaxPayload)}
+	a.True(op.ReportLen() >= len(report))
+	a.NoError(op.Unmarshall(report))
+	a.Equal(report[1:], op.Data)
+
+	a.Error(op.Unmarshall(report[:I2CMaxPayload]))
+}
+
+// Records the report IDs of all output reports
+type reportRecorder struct {
+	*Simulator
+	outputReports []byte
+}
+
+func (r *reportRecorder) DoWrite(b []byte, featureReport bool) (int, error) {
+	if !featureReport && len(b) > 0 {
+		r.outputReports = append(r.outputReports, b[0])
+	}
+	return r.Simulator.DoWrite(b, featureReport)
+}
+
+func Test_i2c_max_payload(t *testing.T) {
+	a := assert.New(t)
+	sim := &reportRecorder{Simulator: NewSimulator()}
+	dev := NewFt260(sim)
+	slave := new(RegisterSlave)
+	sim.Attach(0x20, slave)
+
+	// Register byte and 59 data bytes fill exactly one report with the highest report ID
+	data := make([]byte, I2CMaxPayload-1)
+	for i := range data {
+		data[i] = byte(i + 1)
+	}
+	a.NoError(dev.I2cWrite(0x20, append([]byte{0x00}, data...)...))
+	a.Equal([]byte{ReportID_I2CInOut_Max}, sim.outputReports)
+	a.Equal(data, slave.Registers[:len(data)])
+
+	// A 60 byte read is received in a single input report
+	sim.outputReports = nil
+	read, err := dev.I2cGet(0x20, 0x00, I2CMaxPayload)
+	a.NoError(err)
+	a.Equal([]byte{0xD0, ReportID_I2CRead}, sim.outputReports)
+	a.Equal(data, read[:len(data)])
+}
+
+func Test_i2c_multi_chunk_read(t *testing.T) {
+	a := assert.New(t)
+	sim := &reportRecorder{Simulator: NewSimulator()}
+	dev := NewFt260(sim)
+	slave := new(RegisterSlave)
+	sim.Attach(0x20, slave)
+	for i := range slave.Registers {
+		slave.Registers[i] = byte(i)
+	}
+
+	// Every chunk except the last ends without STOP, so the bus stays busy until the final chunk
+	read, err := dev.I2cGet(0x20, 0x00, 2*I2CMaxPayload+10)
+	a.NoError(err)
+	a.Equal(slave.Registers[:len(read)], read)
+	a.Equal([]byte{0xD0, ReportID_I2CRead, ReportID_I2CRead, ReportID_I2CRead}, sim.outputReports)
+
+	var status ReportI2cStatus
+	a.NoError(dev.Read(&status))
+	a.Equal(I2C_StatusControllerIdle, status.BusStatus)
+}
@@ -0,0 +1,224 @@
+package ft260
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// Timeout of every input report read of the interrupt polling goroutine. Other input reads wait at most this long.
+	InterruptPollTimeout = 10 * time.Millisecond
+	InterruptBufferSize  = 16
+
+	// After consecutive polling errors, the wait time before the next poll is doubled up to this limit.
+	// Errors are logged at most once per InterruptMaxBackoff.
+	InterruptMaxBackoff = time.Second
+)
+
+// Payload of SetSystemSetting_Interrupt
+type InterruptConfig struct {
+	Trigger       byte // InterruptTrigger...
+	LevelDuration byte // InterruptLevelDuration... (only for level triggers)
+}
+
+func (c *InterruptConfig) Validate() error {
+	if c.Trigger > InterruptTriggerLevelLow {
+		return fmt.Errorf("Invalid interrupt trigger %v", c.Trigger)
+	}
+	if !c.IsLevelTrigger() && c.LevelDuration == 0 {
+		return nil
+	}
+	if c.LevelDuration < InterruptLevelDuration1ms || c.LevelDuration > InterruptLevelDuration30ms {
+		return fmt.Errorf("Invalid interrupt level duration %v", c.LevelDuration)
+	}
+	return nil
+}
+
+func (c *InterruptConfig) IsLevelTrigger() bool {
+	return c.Trigger == InterruptTriggerLevelHigh || c.Trigger == InterruptTriggerLevelLow
+}
+
+// Data of ReportID_UARTInterruptStatus Interrupt In. Sent when the interrupt pin (GPIO3) is triggered,
+// or the UART DCD/RI lines change.
+type ReportInterruptStatus struct {
+	Interrupt bool // Bit 0 of the first byte: the interrupt pin was triggered
+	UartDcdRi byte // Bit 0: DCD, bit 1: RI
+}
+
+func (r *ReportInterruptStatus) IsDataReport() bool {
+	return true
+}
+
+func (r *ReportInterruptStatus) ReportID() byte {
+	return ReportID_UARTInterruptStatus
+}
+
+func (r *ReportInterruptStatus) ReportLen() int {
+	return 2
+}
+
+func (r *ReportInterruptStatus) Marshall(b []byte) error {
+	b[0] = _writeBool(r.Interrupt)
+	b[1] = r.UartDcdRi
+	return nil
+}
+
+func (r *ReportInterruptStatus) Unmarshall(b []byte) error {
+	if len(b) < r.ReportLen() {
+		return fmt.Errorf("Short interrupt status report (%v byte)", len(b))
+	}
+	r.Interrupt = b[0]&0x01 != 0
+	r.UartDcdRi = b[1]
+	return nil
+}
+
+type InterruptEvent struct {
+	Time time.Time
+	ReportInterruptStatus
+}
+
+// ConfigureInterrupt enables the interrupt function of GPIO3 with the given trigger condition.
+func (f *Ft260) ConfigureInterrupt(config InterruptConfig) error {
+	err := f.Write(&SetSystemStatus{
+		Request: SetSystemSetting_EnableWakeupInt,
+		Value:   true,
+	})
+	if err == nil {
+		err = f.Write(&SetSystemStatus{
+			Request: SetSystemSetting_Interrupt,
+			Value:   config,
+		})
+	}
+	return err
+}
+
+// Interrupts starts a goroutine polling for input reports and returns a channel receiving all interrupt events.
+// Other input reports received by the goroutine are kept for subsequent reads.
+// Events are dropped when the channel buffer (InterruptBufferSize) is full.
+func (f *Ft260) Interrupts() <-chan InterruptEvent {
+	f.inputLock.Lock()
+	defer f.inputLock.Unlock()
+	if f.interrupts == nil {
+		f.interrupts = make(chan InterruptEvent, InterruptBufferSize)
+		f.stopInterrupts = make(chan struct{})
+		go f.pollInterrupts(f.stopInterrupts)
+	}
+	return f.interrupts
+}
+
+// StopInterrupts stops the goroutine started by Interrupts() and closes the interrupt channel.
+func (f *Ft260) StopInterrupts() {
+	f.inputLock.Lock()
+	defer f.inputLock.Unlock()
+	if f.interrupts != nil {
+		close(f.stopInterrupts)
+		close(f.interrupts)
+		f.interrupts = nil
+		f.stopInterrupts = nil
+	}
+}
+
+func (f *Ft260) pollInterrupts(stop chan struct{}) {
+	data := make([]byte, I2CMaxPayload+2)
+	backoff := InterruptPollTimeout
+	failures := 0
+	for {
+		// Let other input readers take the lock before the next poll
+		for atomic.LoadInt32(&f.inputWaiters) > 0 {
+			runtime.Gosched()
+		}
+		f.inputLock.Lock()
+		select {
+		case <-stop:
+			f.inputLock.Unlock()
+			return
+		default:
+		}
+		n, err := f.Transport.DoRead(data, false, InterruptPollTimeout)
+		if err == nil && n > 0 && !f.dispatchInterrupt(data[:n]) {
+			f.pendingInput = append(f.pendingInput, append([]byte(nil), data[:n]...))
+		}
+		f.inputLock.Unlock()
+
+		if err == nil {
+			if failures > 0 {
+				log.Infof("ft260: Polling for interrupts recovered after %v failures", failures)
+			}
+			backoff, failures = InterruptPollTimeout, 0
+			continue
+		}
+		failures++
+		if failures == 1 || backoff == InterruptMaxBackoff {
+			log.Warnf("ft260: Failed to poll for interrupts (%v failures): %v", failures, err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > InterruptMaxBackoff {
+			backoff = InterruptMaxBackoff
+		}
+	}
+}
+
+// Reads the next input report, which is not an interrupt status report. Must not be called with inputLock held.
+func (f *Ft260) readInput(data []byte, timeout time.Duration) (int, error) {
+	atomic.AddInt32(&f.inputWaiters, 1)
+	f.inputLock.Lock()
+	atomic.AddInt32(&f.inputWaiters, -1)
+	defer f.inputLock.Unlock()
+	if len(f.pendingInput) > 0 {
+		report := f.pendingInput[0]
+		f.pendingInput = f.pendingInput[1:]
+		return copy(data, report), nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		n, err := f.Transport.DoRead(data, false, timeout)
+		if err != nil || n == 0 || !f.dispatchInterrupt(data[:n]) {
+			return n, err
+		}
+		if timeout = deadline.Sub(time.Now()); timeout <= 0 {
+			return 0, nil
+		}
+	}
+}
+
+// Returns true, if the given input report is an interrupt status report. Must be called with inputLock held.
+func (f *Ft260) dispatchInterrupt(report []byte) bool {
+	if report[0] != ReportID_UARTInterruptStatus {
+		return false
+	}
+	var event InterruptEvent
+	if err := event.Unmarshall(report[1:]); err != nil {
+		log.Warnf("ft260: Dropping invalid interrupt status report: %v", err)
+		return true
+	}
+	event.Time = time.Now()
+	if f.interrupts != nil {
+		select {
+		case f.interrupts <- event:
+		default:
+			log.Warnln("ft260: Dropping interrupt event, channel buffer is full")
+		}
+	}
+	return true
+}
+
+// Drops buffered I2C input reports, which are obsolete after resetting the I2C controller
+func (f *Ft260) dropPendingI2cInput() {
+	f.inputLock.Lock()
+	defer f.inputLock.Unlock()
+	pending := f.pendingInput[:0]
+	for _, report := range f.pendingInput {
+		if report[0] < ReportID_I2CInOut || report[0] > ReportID_I2CInOut_Max {
+			pending = append(pending, report)
+		}
+	}
+	f.pendingInput = pending
+}
@@ -0,0 +1,426 @@
+package ft260
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// I2cSlave is a software model of a device attached to the I2C bus of a Simulator.
+type I2cSlave interface {
+	// Receives all bytes written between a START condition and the following STOP or repeated START.
+	I2cSlaveWrite(data []byte) error
+
+	// Fills the given slice with bytes read from the device. Reads that are split into multiple
+	// HID reports result in multiple calls, each continuing where the previous one ended.
+	I2cSlaveRead(data []byte) error
+}
+
+// Simulator is an in-memory FT260 that implements Transport. It understands the system setting,
+// chip code, GPIO, interrupt, I2C and UART reports and forwards I2C transfers to the attached I2cSlave models.
+type Simulator struct {
+	ChipCode  uint32
+	Status    ReportSystemStatus
+	Gpio      ReportGpio
+	I2cStatus ReportI2cStatus
+	Uart      UartConfig
+
+	// Contains all bytes written to the UART
+	UartOutput []byte
+
+	// Fault simulation: the next FailReports written reports and feature reports fail (e.g. a USB disconnect).
+	// Waiting for input reports is not affected, so a concurrent interrupt poll does not consume the failures.
+	// While BusStuck is set, all I2C operations fail with a busy bus, until the I2C bus is reset.
+	FailReports int
+	BusStuck    bool
+
+	// Every I2C operation keeps the controller busy for this duration
+	I2cDelay time.Duration
+
+	lock   sync.Mutex
+	closed bool
+	slaves map[byte]I2cSlave
+	input  [][]byte
+
+	transferActive bool
+	transferRead   bool
+	transferAddr   byte
+	writeBuffer    []byte
+	busyUntil      time.Time
+}
+
+func NewSimulator() *Simulator {
+	return &Simulator{
+		ChipCode: FT260_CHIP_CODE,
+		Status: ReportSystemStatus{
+			ChipMode:    0x01,
+			Clock:       Clock48MHz,
+			PowerStatus: true,
+			I2CEnable:   true,
+		},
+		I2cStatus: ReportI2cStatus{
+			BusStatus: I2C_StatusControllerIdle,
+			BusSpeed:  100,
+		},
+		Uart: UartConfig{
+			FlowControl: UartOff,
+			BaudRate:    9600,
+			DataBits:    8,
+		},
+		slaves: make(map[byte]I2cSlave),
+	}
+}
+
+func (s *Simulator) Attach(addr byte, slave I2cSlave) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.slaves[addr] = slave
+}
+
+func (s *Simulator) Detach(addr byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.slaves, addr)
+}
+
+func (s *Simulator) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *Simulator) DoWrite(b []byte, featureReport bool) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.checkFailure(); err != nil {
+		return 0, err
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	reportID, payload := b[0], b[1:]
+
+	var err error
+	if featureReport {
+		switch reportID {
+		case ReportID_SystemSetting:
+			err = s.writeSystemSetting(payload)
+		case ReportID_GPIO:
+			err = s.Gpio.Unmarshall(payload)
+		default:
+			err = fmt.Errorf("ft260 simulator: unsupported feature report %02x", reportID)
+		}
+	} else {
+		switch {
+		case reportID == ReportID_I2CRead:
+			var op OperationI2cRead
+			if err = op.Unmarshall(payload); err == nil {
+				s.i2cRead(&op)
+			}
+		case reportID >= ReportID_I2CInOut && reportID <= ReportID_I2CInOut_Max:
+			var op OperationI2cWrite
+			if err = op.Unmarshall(payload); err == nil {
+				s.i2cWrite(&op)
+			}
+		case reportID >= ReportID_UART && reportID <= ReportID_UART_Max:
+			var op OperationUartWrite
+			if err = op.Unmarshall(payload); err == nil {
+				s.UartOutput = append(s.UartOutput, op.Payload...)
+			}
+		default:
+			err = fmt.Errorf("ft260 simulator: unsupported output report %02x", reportID)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *Simulator) DoRead(b []byte, featureReport bool, timeout time.Duration) (int, error) {
+	if !featureReport {
+		return s.readInput(b, timeout)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.checkFailure(); err != nil {
+		return 0, err
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var report ReportOut
+	switch b[0] {
+	case ReportID_ChipCode:
+		report = &ReportChipCode{ChipCode: s.ChipCode}
+	case ReportID_SystemSetting:
+		report = &s.Status
+	case ReportID_I2CStatus:
+		report = &s.I2cStatus
+		if time.Now().Before(s.busyUntil) {
+			report = &ReportI2cStatus{BusStatus: I2C_StatusControllerBusy, BusSpeed: s.I2cStatus.BusSpeed}
+		}
+	case ReportID_GPIO:
+		report = &s.Gpio
+	case ReportID_UARTStatus:
+		report = &ReportUartStatus{UartConfig: s.Uart}
+	default:
+		return 0, fmt.Errorf("ft260 simulator: unsupported feature report %02x", b[0])
+	}
+	data := make([]byte, report.ReportLen()+1)
+	data[0] = report.ReportID()
+	if err := report.Marshall(data[1:]); err != nil {
+		return 0, err
+	}
+	return copy(b, data), nil
+}
+
+// Waits for a queued input report. Returns zero bytes after the timeout, like the HID library.
+func (s *Simulator) readInput(b []byte, timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			return 0, errors.New("ft260 simulator: device closed")
+		}
+		if len(s.input) > 0 {
+			data := s.input[0]
+			s.input = s.input[1:]
+			s.lock.Unlock()
+			return copy(b, data), nil
+		}
+		s.lock.Unlock()
+		if !time.Now().Before(deadline) {
+			return 0, nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *Simulator) checkFailure() error {
+	if s.closed {
+		return errors.New("ft260 simulator: device closed")
+	}
+	if s.FailReports > 0 {
+		s.FailReports--
+		return errors.New("ft260 simulator: simulated transport failure")
+	}
+	return nil
+}
+
+func (s *Simulator) writeSystemSetting(payload []byte) error {
+	var req SetSystemStatus
+	if err := req.Unmarshall(payload); err != nil {
+		return err
+	}
+	switch req.Request {
+	case SetSystemSetting_Clock:
+		s.Status.Clock = req.Value.(byte)
+	case SetSystemSetting_EnableWakeupInt:
+		s.Status.EnableWakeupInt = req.Value.(bool)
+	case SetSystemSetting_SuspendOutActiveLow:
+		s.Status.SuspendOutActiveLow = req.Value.(bool)
+	case SetSystemSetting_GPIO_2:
+		s.Status.GPIO2Function = req.Value.(byte)
+	case SetSystemSetting_GPIO_A:
+		s.Status.GPIOAFunction = req.Value.(byte)
+	case SetSystemSetting_GPIO_G:
+		s.Status.GPIOGFunction = req.Value.(byte)
+	case SetSystemSetting_Uart:
+		s.Status.UartMode = req.Value.(byte)
+		s.Uart.FlowControl = s.Status.UartMode
+	case SetSystemSetting_ConfigureUart:
+		s.Uart = req.Value.(UartConfig)
+		s.Status.UartMode = s.Uart.FlowControl
+	case SetSystemSetting_UartBaudRate:
+		s.Uart.BaudRate = req.Value.(uint32)
+	case SetSystemSetting_UartDataBits:
+		s.Uart.DataBits = req.Value.(byte)
+	case SetSystemSetting_UartParity:
+		s.Uart.Parity = req.Value.(byte)
+	case SetSystemSetting_UartStopBits:
+		s.Uart.StopBits = req.Value.(byte)
+	case SetSystemSetting_UartBreaking:
+		s.Uart.Breaking = req.Value.(bool)
+	case SetSystemSetting_Interrupt:
+		config := req.Value.(InterruptConfig)
+		s.Status.InterruptCond = config.Trigger | config.LevelDuration<<2
+	case SetSystemSetting_I2CReset:
+		s.resetI2c()
+	case SetSystemSetting_I2CSetClock:
+		s.I2cStatus.BusSpeed = req.Value.(uint16)
+	default:
+		// Accept the remaining settings without modelling their effect
+	}
+	return nil
+}
+
+// TriggerInterrupt queues an interrupt status report, if the interrupt function of GPIO3 is enabled
+func (s *Simulator) TriggerInterrupt() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.Status.EnableWakeupInt {
+		report := ReportInterruptStatus{Interrupt: true}
+		data := make([]byte, report.ReportLen()+1)
+		data[0] = report.ReportID()
+		_ = report.Marshall(data[1:])
+		s.input = append(s.input, data)
+	}
+}
+
+// UartInput queues the given bytes as received UART data
+func (s *Simulator) UartInput(data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queueInput(ReportID_UART, UartMaxPayload, data)
+}
+
+// Splits data into input reports with a length byte, using the smallest fitting report ID starting at firstReportID
+func (s *Simulator) queueInput(firstReportID byte, maxPayload int, data []byte) {
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > maxPayload {
+			chunk = chunk[:maxPayload]
+		}
+		data = data[len(chunk):]
+		report := make([]byte, len(chunk)+2)
+		report[0] = firstReportID + byte(len(chunk)-1)/4
+		report[1] = byte(len(chunk))
+		copy(report[2:], chunk)
+		s.input = append(s.input, report)
+	}
+}
+
+func (s *Simulator) resetI2c() {
+	s.BusStuck = false
+	s.transferActive = false
+	s.writeBuffer = nil
+	s.input = nil
+	s.I2cStatus.BusStatus = I2C_StatusControllerIdle
+	s.busyUntil = time.Time{}
+}
+
+func (s *Simulator) i2cWrite(op *OperationI2cWrite) {
+	s.busyUntil = time.Now().Add(s.I2cDelay)
+	if s.BusStuck {
+		s.failTransfer(I2C_StatusBusBusy)
+		return
+	}
+	if op.Condition&I2C_MasterStart != 0 {
+		if !s.startTransfer(op.SlaveAddr, false) {
+			return
+		}
+	} else if !s.continueTransfer(op.SlaveAddr, false) {
+		return
+	}
+	s.writeBuffer = append(s.writeBuffer, op.Payload...)
+	s.finishOperation(op.Condition)
+}
+
+func (s *Simulator) i2cRead(op *OperationI2cRead) {
+	s.busyUntil = time.Now().Add(s.I2cDelay)
+	if s.BusStuck {
+		s.failTransfer(I2C_StatusBusBusy)
+		return
+	}
+	if op.Condition&I2C_MasterStart != 0 {
+		if !s.startTransfer(op.SlaveAddr, true) {
+			return
+		}
+	} else if !s.continueTransfer(op.SlaveAddr, true) {
+		return
+	}
+	data := make([]byte, op.Len)
+	if err := s.slaves[op.SlaveAddr].I2cSlaveRead(data); err != nil {
+		s.failTransfer(I2C_StatusNoDataAck)
+		return
+	}
+	s.queueInput(ReportID_I2CInOut, I2CMaxPayload, data)
+	s.finishOperation(op.Condition)
+}
+
+// Handles a START or repeated START condition. Returns false, if the bus operation failed.
+func (s *Simulator) startTransfer(addr byte, read bool) bool {
+	if !s.flushWrite() {
+		return false
+	}
+	if _, ok := s.slaves[addr]; !ok {
+		s.failTransfer(I2C_StatusNoSlaveAck)
+		return false
+	}
+	s.transferActive = true
+	s.transferRead = read
+	s.transferAddr = addr
+	return true
+}
+
+func (s *Simulator) continueTransfer(addr byte, read bool) bool {
+	if !s.transferActive || s.transferAddr != addr || s.transferRead != read {
+		s.failTransfer(0)
+		return false
+	}
+	return true
+}
+
+func (s *Simulator) finishOperation(condition byte) {
+	if condition&I2C_MasterStop != 0 {
+		if s.flushWrite() {
+			s.transferActive = false
+			s.I2cStatus.BusStatus = I2C_StatusControllerIdle
+		}
+	} else {
+		s.I2cStatus.BusStatus = I2C_StatusBusBusy
+	}
+}
+
+// Delivers buffered write data to the addressed slave. Returns false, if the slave rejected the data.
+func (s *Simulator) flushWrite() bool {
+	if !s.transferActive || s.transferRead {
+		return true
+	}
+	data := s.writeBuffer
+	s.writeBuffer = nil
+	if err := s.slaves[s.transferAddr].I2cSlaveWrite(data); err != nil {
+		s.failTransfer(I2C_StatusNoDataAck)
+		return false
+	}
+	return true
+}
+
+func (s *Simulator) failTransfer(status byte) {
+	s.transferActive = false
+	s.writeBuffer = nil
+	s.I2cStatus.BusStatus = I2C_StatusControllerIdle | I2C_StatusError | status
+}
+
+// RegisterSlave is a generic I2cSlave with 256 byte-sized registers. The first byte of every write
+// selects the register address, subsequent bytes are written to consecutive registers.
+// Reads start at the current register address and auto-increment it.
+type RegisterSlave struct {
+	Registers [256]byte
+	Pointer   byte
+}
+
+func (r *RegisterSlave) I2cSlaveWrite(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	r.Pointer = data[0]
+	for _, b := range data[1:] {
+		r.Registers[r.Pointer] = b
+		r.Pointer++
+	}
+	return nil
+}
+
+func (r *RegisterSlave) I2cSlaveRead(data []byte) error {
+	for i := range data {
+		data[i] = r.Registers[r.Pointer]
+		r.Pointer++
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package ft260
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_simulator_system_reports(t *testing.T) {
+	a := assert.New(t)
+	sim := NewSimulator()
+	dev := NewFt260(sim)
+
+	var code ReportChipCode
+	a.NoError(dev.Read(&code))
+	a.Equal(uint32(FT260_CHIP_CODE), code.ChipCode)
+
+	a.NoError(dev.Write(&SetSystemStatus{Request: SetSystemSetting_Clock, Value: Clock24MHz}))
+	a.NoError(dev.Write(&SetSystemStatus{Request: SetSystemSetting_I2CSetClock, Value: uint16(400)}))
+	a.NoError(dev.Write(&SetSystemStatus{Request: SetSystemSetting_EnableWakeupInt, Value: true}))
+
+	var status ReportSystemStatus
+	a.NoError(dev.Read(&status))
+	a.Equal(Clock24MHz, status.Clock)
+	a.True(status.EnableWakeupInt)
+	a.True(status.I2CEnable)
+
+	var i2cStatus ReportI2cStatus
+	a.NoError(dev.Read(&i2cStatus))
+	a.Equal(uint16(400), i2cStatus.BusSpeed)
+	a.Equal(I2C_StatusControllerIdle, i2cStatus.BusStatus)
+}
+
+func Test_simulator_i2c(t *testing.T) {
+	a := assert.New(t)
+	sim := NewSimulator()
+	dev := NewFt260(sim)
+	slave := new(RegisterSlave)
+	sim.Attach(0x20, slave)
+
+	// Long writes and reads are split into multiple HID reports
+	data := make([]byte, 130)
+	for i := range data {
+		data[i] = byte(i + 10)
+	}
+	a.NoError(dev.I2cWrite(0x20, append([]byte{0x05}, data...)...))
+	a.Equal(data, slave.Registers[0x05:0x05+len(data)])
+
+	read, err := dev.I2cGet(0x20, 0x05, len(data))
+	a.NoError(err)
+	a.Equal(data, read)
+
+	a.NoError(dev.I2cWrite(0x20, 0x10))
+	read = make([]byte, 3)
+	a.NoError(dev.I2cRead(0x20, read))
+	a.Equal(data[0x0B:0x0E], read)
+
+	// Missing slave results in NACK, which is ignored by the scan
+	err = dev.I2cWrite(0x21, 0x00)
+	if a.IsType(new(I2cError), err) {
+		a.NotZero(err.(*I2cError).BusStatus & I2C_StatusNoSlaveAck)
+	}
+	sim.Attach(0x50, new(RegisterSlave))
+	slaves, err := I2cScan(dev)
+	a.NoError(err)
+	a.Equal([]byte{0x20, 0x50}, slaves)
+}
+
+func Test_simulator_uart(t *testing.T) {
+	a := assert.New(t)
+	sim := NewSimulator()
+	dev := NewFt260(sim)
+
+	config := UartConfig{
+		FlowControl: UartNoFlowControl,
+		BaudRate:    115200,
+		DataBits:    8,
+		Parity:      UartParityEven,
+		StopBits:    UartStopBitsTwo,
+	}
+	uart, err := dev.OpenUart(config)
+	a.NoError(err)
+	a.NoError(dev.SetUartBaudRate(57600))
+	status, err := dev.UartStatus()
+	a.NoError(err)
+	config.BaudRate = 57600
+	a.Equal(config, status)
+
+	_, err = dev.OpenUart(UartConfig{BaudRate: 10, DataBits: 8})
+	a.Error(err)
+
+	out := make([]byte, 100)
+	for i := range out {
+		out[i] = byte(i)
+	}
+	n, err := uart.Write(out)
+	a.NoError(err)
+	a.Equal(len(out), n)
+	a.Equal(out, sim.UartOutput)
+
+	sim.UartInput([]byte("$GPGGA,123519"))
+	in := make([]byte, 6)
+	n, err = uart.Read(in)
+	a.NoError(err)
+	a.Equal("$GPGGA", string(in[:n]))
+	n, err = uart.Read(in)
+	a.NoError(err)
+	a.Equal(",12351", string(in[:n]))
+
+	a.NoError(uart.Close())
+	_, err = uart.Write(out)
+	a.Error(err)
+	_, err = uart.Read(in)
+	a.Equal(io.EOF, err)
+	a.NoError(uart.Close())
+
+	// The device stays open
+	_, err = dev.UartStatus()
+	a.NoError(err)
+}
+
+func Test_simulator_gpio(t *testing.T) {
+	a := assert.New(t)
+	sim := NewSimulator()
+	dev := NewFt260(sim)
+
+	a.Equal("GPIO3", GPIO3.String())
+	a.Equal("GPIOH", GPIOH.String())
+	pin, err := ParseGpioPin("gpiob")
+	a.NoError(err)
+	a.Equal(GPIOB, pin)
+
+	a.NoError(dev.GpioSetDirection(GPIO4, true))
+	a.NoError(dev.GpioWrite(GPIO4, true))
+	a.NoError(dev.GpioSetDirection(GPIOH, true))
+	a.NoError(dev.GpioWrite(GPIOH, true))
+	a.Equal(ReportGpio{Value: 0x10, Dir: 0x10, ValueEx: 0x80, DirEx: 0x80}, sim.Gpio)
+	a.NoError(dev.GpioWrite(GPIO4, false))
+	a.Equal(byte(0), sim.Gpio.Value)
+
+	// Level and direction in one write
+	a.NoError(dev.GpioSetOutput(GPIO5, true))
+	a.Equal(ReportGpio{Value: 0x20, Dir: 0x30, ValueEx: 0x80, DirEx: 0x80}, sim.Gpio)
+
+	// Input pin
+	a.Error(dev.GpioWrite(GPIOB, true))
+	sim.Gpio.ValueEx |= 0x02
+	high, err := dev.GpioRead(GPIOB)
+	a.NoError(err)
+	a.True(high)
+
+	// Pins claimed by alternate functions
+	_, err = dev.GpioRead(GPIO0)
+	a.Error(err)
+	sim.Status.GPIOGFunction = GPIO_G_RxLed
+	a.Error(dev.GpioSetDirection(GPIOG, true))
+	sim.Status.UartMode = UartRTS_CTS
+	a.Error(dev.GpioSetDirection(GPIOE, true))
+	a.NoError(dev.GpioSetDirection(GPIOF, true))
+	a.Error(dev.GpioSetDirection(GpioPin(20), true))
+}
+
+func Test_simulator_interrupt(t *testing.T) {
+	a := assert.New(t)
+	sim := NewSimulator()
+	dev := NewFt260(sim)
+	slave := new(RegisterSlave)
+	sim.Attach(0x48, slave)
+
+	a.Error(dev.ConfigureInterrupt(InterruptConfig{Trigger: 7, LevelDuration: InterruptLevelDuration1ms}))
+	a.Error(dev.ConfigureInterrupt(InterruptConfig{Trigger: InterruptTriggerLevelHigh}))
+	a.NoError(dev.ConfigureInterrupt(InterruptConfig{Trigger: InterruptTriggerRisingEdge}))
+	a.NoError(dev.ConfigureInterrupt(InterruptConfig{Trigger: InterruptTriggerLevelLow, LevelDuration: InterruptLevelDuration5ms}))
+	var status ReportSystemStatus
+	a.NoError(dev.Read(&status))
+	a.True(status.EnableWakeupInt)
+	a.Equal(InterruptTriggerLevelLow, status.InterruptTriggerCondition())
+	a.Equal(InterruptLevelDuration5ms, status.InterruptLevelDuration())
+
+	// Interrupt reports between I2C operations must not disturb reading I2C data
+	sim.TriggerInterrupt()
+	slave.Registers[0] = 0x42
+	val, err := dev.I2cGet(0x48, 0, 1)
+	a.NoError(err)
+	a.Equal([]byte{0x42}, val)
+
+	interrupts := dev.Interrupts()
+	sim.TriggerInterrupt()
+	select {
+	case event := <-interrupts:
+		a.True(event.Interrupt)
+	case <-time.After(time.Second):
+		a.Fail("No interrupt event received")
+	}
+	val, err = dev.I2cGet(0x48, 0, 1)
+	a.NoError(err)
+	a.Equal([]byte{0x42}, val)
+
+	// Input reports of an aborted read are dropped when resetting the I2C controller
+	a.NoError(dev.Write(&OperationI2cRead{SlaveAddr: 0x48, Condition: I2C_MasterStartStop, Len: 1}))
+	for start := time.Now(); pendingInput(dev) == 0 && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	a.Equal(1, pendingInput(dev))
+	a.NoError(dev.Write(&SetSystemStatus{Request: SetSystemSetting_I2CReset}))
+	a.Equal(0, pendingInput(dev))
+	slave.Registers[0] = 0x43
+	val, err = dev.I2cGet(0x48, 0, 1)
+	a.NoError(err)
+	a.Equal([]byte{0x43}, val)
+
+	a.NoError(dev.Close())
+	_, ok := <-interrupts
+	a.False(ok)
+}
+
+func Test_simulator_i2c_context(t *testing.T) {
+	a := assert.New(t)
+	sim := NewSimulator()
+	dev := NewFt260(sim)
+	sim.Attach(0x20, new(RegisterSlave))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.True(errors.Is(dev.I2cWriteContext(ctx, 0x20, 0x00, 0x01), context.Canceled))
+
+	// The status polling is aborted at the deadline, and the I2C controller is reset
+	defer func(checks int) { I2cNumChecks = checks }(I2cNumChecks)
+	I2cNumChecks = 1000000
+	sim.I2cDelay = time.Second
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := dev.I2cGetContext(ctx, 0x20, 0x00, 1)
+	a.True(errors.Is(err, context.DeadlineExceeded), "Unexpected error: %v", err)
+	a.True(time.Since(start) < 300*time.Millisecond)
+
+	sim.I2cDelay = 0
+	a.NoError(dev.I2cWrite(0x20, 0x00, 0x05))
+	val, err := dev.I2cGet(0x20, 0x00, 1)
+	a.NoError(err)
+	a.Equal([]byte{0x05}, val)
+}
+
+func pendingInput(dev *Ft260) int {
+	dev.inputLock.Lock()
+	defer dev.inputLock.Unlock()
+	return len(dev.pendingInput)
+}
+
+func Test_interrupt_poll_backoff(t *testing.T) {
+	a := assert.New(t)
+	sim := NewSimulator()
+	dev := NewFt260(sim)
+	sim.Attach(0x48, new(RegisterSlave))
+	dev.Interrupts()
+
+	// Input reads are not blocked by the polling goroutine
+	for i := 0; i < 20; i++ {
+		_, err := dev.I2cGet(0x48, 0, 1)
+		a.NoError(err)
+	}
+
+	// Failing polls back off, instead of retrying every InterruptPollTimeout
+	var polls int32
+	dev.inputLock.Lock()
+	dev.Transport = &countingTransport{Transport: failingTransport{}, reads: &polls}
+	dev.inputLock.Unlock()
+	time.Sleep(300 * time.Millisecond)
+	a.True(atomic.LoadInt32(&polls) < 8, "Too many polls: %v", atomic.LoadInt32(&polls))
+	dev.StopInterrupts()
+}
+
+type failingTransport struct{}
+
+func (failingTransport) DoWrite(b []byte, featureReport bool) (int, error) {
+	return 0, errors.New("failing transport")
+}
+
+func (failingTransport) DoRead(b []byte, featureReport bool, timeout time.Duration) (int, error) {
+	return 0, errors.New("failing transport")
+}
+
+func (failingTransport) Close() error {
+	return nil
+}
+
+type countingTransport struct {
+	Transport
+	reads *int32
+}
+
+func (c *countingTransport) DoRead(b []byte, featureReport bool, timeout time.Duration) (int, error) {
+	atomic.AddInt32(c.reads, 1)
+	return c.Transport.DoRead(b, featureReport, timeout)
+}
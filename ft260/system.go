@@ -97,6 +97,11 @@ func (r *ReportChipCode) Unmarshall(b []byte) error {
 	return nil
 }
 
+func (r *ReportChipCode) Marshall(b []byte) error {
+	b[0], b[1], b[2], b[3] = byte(r.ChipCode>>24), byte(r.ChipCode>>16), byte(r.ChipCode>>8), byte(r.ChipCode)
+	return nil
+}
+
 // Result of ReportID_SystemSetting Feature In
 type ReportSystemStatus struct {
 	ChipMode            byte // Bit 0: DCNF0, Bit 1: DCNF1
@@ -151,6 +156,24 @@ func (r *ReportSystemStatus) Unmarshall(b []byte) (err error) {
 	return
 }
 
+func (r *ReportSystemStatus) Marshall(b []byte) error {
+	b[0] = r.ChipMode
+	b[1] = r.Clock
+	b[2] = _writeBool(r.Suspended)
+	b[3] = _writeBool(r.PowerStatus)
+	b[4] = _writeBool(r.I2CEnable)
+	b[5] = r.UartMode
+	b[6] = _writeBool(r.HidOverI2cEnable)
+	b[7] = r.GPIO2Function
+	b[8] = r.GPIOAFunction
+	b[9] = r.GPIOGFunction
+	b[10] = _writeBool(r.SuspendOutActiveLow)
+	b[11] = _writeBool(r.EnableWakeupInt)
+	b[12] = r.InterruptCond
+	b[13] = _writeBool(r.EnablePowerSaving)
+	return nil
+}
+
 type SetSystemStatus struct {
 	Request byte
 	Value   interface{}
@@ -208,18 +231,26 @@ func (r *SetSystemStatus) Marshall(b []byte) error {
 		if !ok {
 			return fmt.Errorf("System Setting Request ID %02x expects type %T, but got value of type %T (%v)", r.Request, false, r.Value, r.Value)
 		}
-		if val {
-			b[1] = 1
-		} else {
-			b[1] = 0
-		}
+		b[1] = _writeBool(val)
 
 	case SetSystemSetting_Interrupt:
-		//= 0x0A // 2 byte: InterruptTrigger..., InterruptLevelDuration...
-		return errors.New("Configuring intterupt is not implemented")
+		val, ok := r.Value.(InterruptConfig)
+		if !ok {
+			return fmt.Errorf("System Setting Request ID %02x expects type %T, but got value of type %T (%v)", r.Request, InterruptConfig{}, r.Value, r.Value)
+		}
+		if err := val.Validate(); err != nil {
+			return err
+		}
+		b[1], b[2] = val.Trigger, val.LevelDuration
+		if b[2] == 0 {
+			b[2] = InterruptLevelDuration1ms // Ignored for edge triggers
+		}
 	case SetSystemSetting_UartXonXoff:
-		//= 0x49 // 2 byte (xon char, xoff char)
-		return errors.New("Configuring Uart XON/XOFF is not implemented")
+		val, ok := r.Value.(UartXonXoff)
+		if !ok {
+			return fmt.Errorf("System Setting Request ID %02x expects type %T, but got value of type %T (%v)", r.Request, UartXonXoff{}, r.Value, r.Value)
+		}
+		b[1], b[2] = val.Xon, val.Xoff
 	case SetSystemSetting_I2CSetClock:
 		val, ok := r.Value.(uint16)
 		if !ok {
@@ -227,11 +258,69 @@ func (r *SetSystemStatus) Marshall(b []byte) error {
 		}
 		b[1], b[2] = byte(val), byte(val>>8)
 	case SetSystemSetting_ConfigureUart:
-		return errors.New("Configuring UART is not implemented")
+		val, ok := r.Value.(UartConfig)
+		if !ok {
+			return fmt.Errorf("System Setting Request ID %02x expects type %T, but got value of type %T (%v)", r.Request, UartConfig{}, r.Value, r.Value)
+		}
+		return val.Marshall(b[1:])
 	case SetSystemSetting_UartBaudRate:
-		return errors.New("Configuring UART baud rate is not implemented")
+		val, ok := r.Value.(uint32)
+		if !ok {
+			return fmt.Errorf("System Setting Request ID %02x expects type %T, but got value of type %T (%v)", r.Request, uint32(0), r.Value, r.Value)
+		}
+		if val < UartBaudRateMin || val > UartBaudRateMax {
+			return fmt.Errorf("Invalid UART baud rate %v (must be %v..%v)", val, UartBaudRateMin, UartBaudRateMax)
+		}
+		b[1], b[2], b[3], b[4] = byte(val), byte(val>>8), byte(val>>16), byte(val>>24)
 	default:
 		return fmt.Errorf("Unknown system setting request ID: %v", r.Request)
 	}
 	return nil
 }
+
+// Inverse of Marshall, used by the Simulator to decode received requests
+func (r *SetSystemStatus) Unmarshall(b []byte) error {
+	if len(b) < 1 {
+		return errors.New("Empty system setting request")
+	}
+	r.Request = b[0]
+	r.Value = nil
+	switch r.Request {
+	case SetSystemSetting_I2CReset, SetSystemSetting_UartReset:
+		// No payload
+		return nil
+	case SetSystemSetting_Clock, SetSystemSetting_GPIO_2, SetSystemSetting_GPIO_A, SetSystemSetting_GPIO_G,
+		SetSystemSetting_EnableWakeupInt, SetSystemSetting_SuspendOutActiveLow, SetSystemSetting_EnableUartDcdRi,
+		SetSystemSetting_EnableUartRiWakeup, SetSystemSetting_UartRiWakeupFallingEdge, SetSystemSetting_UartBreaking,
+		SetSystemSetting_Uart, SetSystemSetting_UartDataBits, SetSystemSetting_UartParity, SetSystemSetting_UartStopBits,
+		SetSystemSetting_I2CSetClock, SetSystemSetting_UartXonXoff, SetSystemSetting_ConfigureUart, SetSystemSetting_UartBaudRate,
+		SetSystemSetting_Interrupt:
+	default:
+		return fmt.Errorf("Unsupported system setting request ID: %02x", r.Request)
+	}
+	if len(b) < r.ReportLen() {
+		return fmt.Errorf("System setting request ID %02x too short (%v byte, need %v)", r.Request, len(b), r.ReportLen())
+	}
+
+	var err error
+	switch r.Request {
+	case SetSystemSetting_EnableWakeupInt, SetSystemSetting_SuspendOutActiveLow, SetSystemSetting_EnableUartDcdRi,
+		SetSystemSetting_EnableUartRiWakeup, SetSystemSetting_UartRiWakeupFallingEdge, SetSystemSetting_UartBreaking:
+		r.Value = _readBool(b, 1, &err)
+	case SetSystemSetting_I2CSetClock:
+		r.Value = uint16(b[1]) + uint16(b[2])<<8
+	case SetSystemSetting_UartXonXoff:
+		r.Value = UartXonXoff{Xon: b[1], Xoff: b[2]}
+	case SetSystemSetting_Interrupt:
+		r.Value = InterruptConfig{Trigger: b[1], LevelDuration: b[2]}
+	case SetSystemSetting_ConfigureUart:
+		var config UartConfig
+		err = config.Unmarshall(b[1:])
+		r.Value = config
+	case SetSystemSetting_UartBaudRate:
+		r.Value = uint32(b[1]) + uint32(b[2])<<8 + uint32(b[3])<<16 + uint32(b[4])<<24
+	default:
+		r.Value = b[1]
+	}
+	return err
+}
@@ -1,8 +1,316 @@
 package ft260
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
 const (
 	ReportID_UARTInterruptStatus = 0xB1 // Input
 	ReportID_UARTStatus          = 0xE0 // Feature
 	ReportID_UARTRiDcdStatus     = 0xE2 // Feature
 	ReportID_UART                = 0xF0 // 0xF0 - 0xFE, Input, Output
+	ReportID_UART_Max            = 0xFE
+
+	UartMaxPayload = (1 + ReportID_UART_Max - ReportID_UART) * 4
+
+	UartBaudRateMin = 1200
+	UartBaudRateMax = 12000000
 )
+
+const (
+	// UartConfig.Parity
+	UartParityNone  = byte(0)
+	UartParityOdd   = byte(1)
+	UartParityEven  = byte(2)
+	UartParityHigh  = byte(3)
+	UartParityLow   = byte(4)
+	UartStopBitsOne = byte(0)
+	UartStopBitsTwo = byte(2)
+)
+
+// Payload of SetSystemSetting_ConfigureUart, also returned by ReportUartStatus
+type UartConfig struct {
+	FlowControl byte   // Uart... (see ReportSystemStatus.UartMode)
+	BaudRate    uint32 // UartBaudRateMin..UartBaudRateMax
+	DataBits    byte   // 7..8
+	Parity      byte   // UartParity...
+	StopBits    byte   // UartStopBits...
+	Breaking    bool
+}
+
+func (c *UartConfig) Validate() error {
+	if c.FlowControl > UartNoFlowControl {
+		return fmt.Errorf("Invalid UART flow control %v", c.FlowControl)
+	}
+	if c.BaudRate < UartBaudRateMin || c.BaudRate > UartBaudRateMax {
+		return fmt.Errorf("Invalid UART baud rate %v (must be %v..%v)", c.BaudRate, UartBaudRateMin, UartBaudRateMax)
+	}
+	if c.DataBits != 7 && c.DataBits != 8 {
+		return fmt.Errorf("Invalid number of UART data bits %v (must be 7 or 8)", c.DataBits)
+	}
+	if c.Parity > UartParityLow {
+		return fmt.Errorf("Invalid UART parity %v", c.Parity)
+	}
+	if c.StopBits != UartStopBitsOne && c.StopBits != UartStopBitsTwo {
+		return fmt.Errorf("Invalid UART stop bits %v", c.StopBits)
+	}
+	return nil
+}
+
+func (c *UartConfig) Marshall(b []byte) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	b[0] = c.FlowControl
+	b[1], b[2], b[3], b[4] = byte(c.BaudRate), byte(c.BaudRate>>8), byte(c.BaudRate>>16), byte(c.BaudRate>>24)
+	b[5] = c.DataBits
+	b[6] = c.Parity
+	b[7] = c.StopBits
+	b[8] = _writeBool(c.Breaking)
+	return nil
+}
+
+func (c *UartConfig) Unmarshall(b []byte) (err error) {
+	c.FlowControl = b[0]
+	c.BaudRate = uint32(b[1]) + uint32(b[2])<<8 + uint32(b[3])<<16 + uint32(b[4])<<24
+	c.DataBits = b[5]
+	c.Parity = b[6]
+	c.StopBits = b[7]
+	c.Breaking = _readBool(b, 8, &err)
+	return
+}
+
+// Payload of SetSystemSetting_UartXonXoff
+type UartXonXoff struct {
+	Xon  byte
+	Xoff byte
+}
+
+// Result of ReportID_UARTStatus Feature In
+type ReportUartStatus struct {
+	UartConfig
+}
+
+func (r *ReportUartStatus) ReportID() byte {
+	return ReportID_UARTStatus
+}
+
+func (r *ReportUartStatus) ReportLen() int {
+	return 9
+}
+
+// Data of ReportID_UART Interrupt Out
+type OperationUartWrite struct {
+	// 1 byte payload len
+	Payload []byte
+}
+
+func (r *OperationUartWrite) IsDataReport() bool {
+	return true
+}
+
+func (r *OperationUartWrite) ReportID() byte {
+	// Report 0xF0 carries up to 4 byte, every following report ID 4 byte more
+	if len(r.Payload) == 0 {
+		return ReportID_UART
+	}
+	return ReportID_UART + byte(len(r.Payload)-1)/4
+}
+
+func (r *OperationUartWrite) ReportLen() int {
+	return len(r.Payload) + 1
+}
+
+func (r *OperationUartWrite) Marshall(b []byte) error {
+	if len(r.Payload) > UartMaxPayload {
+		return fmt.Errorf("Payload len %v exceeds maximum size of %v", len(r.Payload), UartMaxPayload)
+	}
+	b[0] = byte(len(r.Payload))
+	copy(b[1:], r.Payload)
+	return nil
+}
+
+func (r *OperationUartWrite) Unmarshall(b []byte) error {
+	if len(b) < 1 || len(b) < int(b[0])+1 {
+		return fmt.Errorf("UART write request too short (%v byte)", len(b))
+	}
+	r.Payload = append([]byte(nil), b[1:1+b[0]]...)
+	return nil
+}
+
+// Data of ReportID_UART Interrupt In
+type OperationUartInput struct {
+	// 1 byte payload length
+	Data []byte
+}
+
+func (r *OperationUartInput) IsDataReport() bool {
+	return true
+}
+
+func (r *OperationUartInput) IsVariableSize() bool {
+	return true
+}
+
+func (r *OperationUartInput) IsVariableReportID() bool {
+	return true
+}
+
+func (r *OperationUartInput) ReportID() byte {
+	return ReportID_UART
+}
+
+func (r *OperationUartInput) ReportLen() int {
+	return UartMaxPayload + 1 // Max possible report length: payload length byte and payload
+}
+
+func (r *OperationUartInput) Unmarshall(d []byte) error {
+	l := d[0]
+	if len(d) < int(l)+1 {
+		return fmt.Errorf("Short UART read (%v, needed at least %v)", len(d), l+1)
+	}
+	r.Data = append(r.Data[:0], d[1:1+l]...)
+	return nil
+}
+
+func (f *Ft260) ConfigureUart(config UartConfig) error {
+	return f.Write(&SetSystemStatus{
+		Request: SetSystemSetting_ConfigureUart,
+		Value:   config,
+	})
+}
+
+func (f *Ft260) SetUartBaudRate(baudRate uint32) error {
+	return f.Write(&SetSystemStatus{
+		Request: SetSystemSetting_UartBaudRate,
+		Value:   baudRate,
+	})
+}
+
+func (f *Ft260) SetUartXonXoff(xon, xoff byte) error {
+	return f.Write(&SetSystemStatus{
+		Request: SetSystemSetting_UartXonXoff,
+		Value:   UartXonXoff{Xon: xon, Xoff: xoff},
+	})
+}
+
+func (f *Ft260) ResetUart() error {
+	return f.Write(&SetSystemStatus{
+		Request: SetSystemSetting_UartReset,
+	})
+}
+
+func (f *Ft260) UartStatus() (UartConfig, error) {
+	var status ReportUartStatus
+	err := f.Read(&status)
+	return status.UartConfig, err
+}
+
+// OpenUart configures the UART and returns a Uart that transfers data through the UART data reports.
+// The FT260 exposes the UART as a separate HID interface, so the Ft260 should be opened through the device path
+// of that interface. Closing the returned Uart stops reading UART data, but leaves the Ft260 open.
+func (f *Ft260) OpenUart(config UartConfig) (*Uart, error) {
+	if err := f.ConfigureUart(config); err != nil {
+		return nil, err
+	}
+	return &Uart{
+		dev: f,
+	}, nil
+}
+
+// Uart implements io.ReadWriteCloser on top of the UART data reports of an Ft260.
+type Uart struct {
+	dev *Ft260
+
+	readLock  sync.Mutex
+	writeLock sync.Mutex
+	buffer    []byte
+
+	closeLock sync.Mutex
+	closed    bool
+}
+
+var _ io.ReadWriteCloser = new(Uart)
+
+func (u *Uart) Write(data []byte) (int, error) {
+	u.writeLock.Lock()
+	defer u.writeLock.Unlock()
+	if u.isClosed() {
+		return 0, errors.New("ft260: UART closed")
+	}
+	written := 0
+	for written < len(data) {
+		chunk := data[written:]
+		if len(chunk) > UartMaxPayload {
+			chunk = chunk[:UartMaxPayload]
+		}
+		if err := u.dev.Write(&OperationUartWrite{Payload: chunk}); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Read blocks until at least one byte was received, or the Uart is closed.
+func (u *Uart) Read(data []byte) (int, error) {
+	u.readLock.Lock()
+	defer u.readLock.Unlock()
+	for len(u.buffer) == 0 {
+		if u.isClosed() {
+			return 0, io.EOF
+		}
+		if err := u.receive(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(data, u.buffer)
+	u.buffer = u.buffer[n:]
+	return n, nil
+}
+
+// Reads at most one input report. The HID read timeout is not treated as error.
+func (u *Uart) receive() error {
+	var report OperationUartInput
+	data := make([]byte, report.ReportLen()+1)
+	n, err := u.dev.readInput(data, ReadReportTimeout)
+	if err != nil || n == 0 {
+		return err
+	}
+	if data[0] < ReportID_UART || data[0] > ReportID_UART_Max || n < 2 {
+		return fmt.Errorf("Unexpected UART input report %02x (len %v)", data[0], n)
+	}
+	if err := report.Unmarshall(data[1:n]); err != nil {
+		return err
+	}
+	u.buffer = append(u.buffer, report.Data...)
+	return nil
+}
+
+func (u *Uart) isClosed() bool {
+	u.closeLock.Lock()
+	defer u.closeLock.Unlock()
+	return u.closed
+}
+
+// Close stops reading and releases the Ft260, without closing it. Pending reads return within ReadReportTimeout.
+func (u *Uart) Close() error {
+	u.closeLock.Lock()
+	if u.closed {
+		u.closeLock.Unlock()
+		return nil
+	}
+	u.closed = true
+	u.closeLock.Unlock()
+
+	u.readLock.Lock()
+	defer u.readLock.Unlock()
+	u.writeLock.Lock()
+	defer u.writeLock.Unlock()
+	u.buffer = nil
+	u.dev = nil
+	return nil
+}
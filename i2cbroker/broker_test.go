@@ -0,0 +1,109 @@
+package i2cbroker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antongulenko/tank/ft260"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_broker(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "i2cbroker")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "i2c.sock")
+
+	sim := ft260.NewSimulator()
+	slave := new(ft260.RegisterSlave)
+	sim.Attach(0x40, slave)
+	server := NewServer(ft260.NewFt260(sim))
+	a.NoError(server.Listen(socket))
+	client, err := Dial(socket)
+	if !a.NoError(err) {
+		return
+	}
+	defer client.Close()
+
+	a.NoError(client.I2cWrite(0x40, 0x10, 1, 2, 3))
+	a.Equal([]byte{1, 2, 3}, slave.Registers[0x10:0x13])
+	data, err := client.I2cGet(0x40, 0x11, 2)
+	a.NoError(err)
+	a.Equal([]byte{2, 3}, data)
+	in := make([]byte, 3)
+	a.NoError(client.I2cWriteRead(0x40, []byte{0x10}, in))
+	a.Equal([]byte{1, 2, 3}, in)
+	in = make([]byte, 1)
+	a.NoError(client.I2cRead(0x40, in))
+	a.Equal([]byte{0}, in)
+
+	// I2C errors keep the bus status, so scanning works through the broker
+	var i2cErr *ft260.I2cError
+	a.True(errors.As(client.I2cWrite(0x41, 0), &i2cErr))
+	slaves, err := ft260.I2cScanRange(client, 0x40, 0x42)
+	a.NoError(err)
+	a.Equal([]byte{0x40}, slaves)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Equal(context.Canceled, client.I2cWriteContext(ctx, 0x40, 0))
+
+	a.NoError(server.Close())
+	a.Error(client.I2cWrite(0x40, 0))
+	_, err = os.Stat(socket)
+	a.True(os.IsNotExist(err))
+}
+
+type contextRecordingBus struct {
+	ft260.I2cBusContext
+	ctx context.Context
+}
+
+func (b *contextRecordingBus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	b.ctx = ctx
+	return b.I2cBusContext.I2cWriteContext(ctx, addr, data...)
+}
+
+type testContextKey struct{}
+
+func Test_broker_limits(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "i2cbroker")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "i2c.sock")
+
+	sim := ft260.NewSimulator()
+	sim.Attach(0x40, new(ft260.RegisterSlave))
+	bus := &contextRecordingBus{I2cBusContext: ft260.NewFt260(sim)}
+	server := NewServer(bus)
+	server.BaseContext = context.WithValue(context.Background(), testContextKey{}, "base")
+	a.NoError(server.Listen(socket))
+	defer server.Close()
+	client, err := Dial(socket)
+	if !a.NoError(err) {
+		return
+	}
+	defer client.Close()
+
+	// Operations are executed with the base context of the server
+	a.NoError(client.I2cWrite(0x40, 0x10, 1))
+	if a.NotNil(bus.ctx) {
+		a.Equal("base", bus.ctx.Value(testContextKey{}))
+	}
+
+	// Read sizes are checked before allocating the buffer
+	_, err = client.I2cGet(0x40, 0x10, -1)
+	a.Error(err)
+	_, err = client.I2cGet(0x40, 0x10, MaxReadSize+1)
+	a.Error(err)
+	a.Error(client.I2cRead(0x40, make([]byte, MaxReadSize+1)))
+	data, err := client.I2cGet(0x40, 0x10, 1)
+	a.NoError(err)
+	a.Equal([]byte{1}, data)
+}
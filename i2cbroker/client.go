@@ -0,0 +1,142 @@
+package i2cbroker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Client implements ft260.I2cBus by forwarding all operations to a Server. It is safe for concurrent use.
+type Client struct {
+	conn    net.Conn
+	encoder *json.Encoder
+
+	lock    sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *response
+	err     error // Set when the connection failed, all further operations fail with this error
+}
+
+// Dial connects to the Unix domain socket of a Server
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+		pending: make(map[uint64]chan *response),
+	}
+	go c.receive()
+	return c
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) receive() {
+	decoder := json.NewDecoder(bufio.NewReader(c.conn))
+	for {
+		resp := new(response)
+		if err := decoder.Decode(resp); err != nil {
+			c.fail(fmt.Errorf("I2C broker connection failed: %w", err))
+			return
+		}
+		c.lock.Lock()
+		result, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.lock.Unlock()
+		if ok {
+			result <- resp
+		}
+	}
+}
+
+func (c *Client) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.err = err
+	for id, result := range c.pending {
+		result <- &response{ID: id, Error: err.Error()}
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) do(ctx context.Context, req *request) ([]byte, error) {
+	result := make(chan *response, 1)
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	req.ID = c.nextID
+	c.pending[req.ID] = result
+	err := c.encoder.Encode(req)
+	c.lock.Unlock()
+	if err != nil {
+		c.forget(req.ID)
+		return nil, fmt.Errorf("Failed to send I2C broker request: %w", err)
+	}
+
+	select {
+	case resp := <-result:
+		return resp.Data, resp.err()
+	case <-ctx.Done():
+		// The server still executes the operation, but the response is ignored
+		c.forget(req.ID)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) forget(id uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.pending, id)
+}
+
+func (c *Client) I2cWrite(addr byte, data ...byte) error {
+	return c.I2cWriteContext(context.Background(), addr, data...)
+}
+
+func (c *Client) I2cRead(addr byte, data []byte) error {
+	return c.I2cReadContext(context.Background(), addr, data)
+}
+
+func (c *Client) I2cWriteRead(addr byte, out, in []byte) error {
+	return c.I2cWriteReadContext(context.Background(), addr, out, in)
+}
+
+func (c *Client) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	return c.I2cGetContext(context.Background(), addr, registerAddr, size)
+}
+
+func (c *Client) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	_, err := c.do(ctx, &request{Op: opWrite, Addr: addr, Data: data})
+	return err
+}
+
+func (c *Client) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
+	result, err := c.do(ctx, &request{Op: opRead, Addr: addr, ReadSize: len(data)})
+	copy(data, result)
+	return err
+}
+
+func (c *Client) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
+	result, err := c.do(ctx, &request{Op: opWriteRead, Addr: addr, Data: out, ReadSize: len(in)})
+	copy(in, result)
+	return err
+}
+
+func (c *Client) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error) {
+	return c.do(ctx, &request{Op: opGet, Addr: addr, Register: registerAddr, ReadSize: size})
+}
@@ -0,0 +1,55 @@
+package i2cbroker
+
+import (
+	"errors"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+// Requests and responses are exchanged as JSON objects, one per line. Responses can be sent out of order,
+// they are matched to the requests through the ID.
+
+const (
+	opWrite     = "write"
+	opRead      = "read"
+	opWriteRead = "write/read"
+	opGet       = "get"
+)
+
+type request struct {
+	ID       uint64 `json:"id"`
+	Op       string `json:"op"`
+	Addr     byte   `json:"addr"`
+	Data     []byte `json:"data,omitempty"`     // Written data
+	ReadSize int    `json:"read,omitempty"`     // Number of bytes to read
+	Register byte   `json:"register,omitempty"` // Only for get
+}
+
+type response struct {
+	ID       uint64          `json:"id"`
+	Data     []byte          `json:"data,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	I2cError *ft260.I2cError `json:"i2c_error,omitempty"` // Transferred separately, so that clients can inspect the bus status
+}
+
+func (r *response) setError(err error) {
+	if err == nil {
+		return
+	}
+	var i2cErr *ft260.I2cError
+	if errors.As(err, &i2cErr) {
+		r.I2cError = i2cErr
+	} else {
+		r.Error = err.Error()
+	}
+}
+
+func (r *response) err() error {
+	if r.I2cError != nil {
+		return r.I2cError
+	}
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	return nil
+}
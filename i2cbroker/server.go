@@ -0,0 +1,177 @@
+package i2cbroker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/antongulenko/tank/ft260"
+	log "github.com/sirupsen/logrus"
+)
+
+// Maximum number of bytes a client can read with one request
+var MaxReadSize = 4096
+
+// Server executes the I2C operations of all connected clients on one bus. Requests are executed concurrently,
+// so the bus must serialize them itself (e.g. the I2C sequencer of the tank).
+type Server struct {
+	Bus ft260.I2cBus
+
+	// Optional. The operations of all clients are executed with contexts derived from this one,
+	// e.g. to give them a lower priority in the I2C sequencer of the tank.
+	BaseContext context.Context
+
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+func NewServer(bus ft260.I2cBus) *Server {
+	return &Server{
+		Bus:   bus,
+		conns: make(map[net.Conn]bool),
+	}
+}
+
+// Listen creates the Unix domain socket and serves clients in the background. A stale socket file is removed first.
+func (s *Server) Listen(path string) error {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.Serve(listener); err != nil {
+			log.Errorf("I2C broker on %v stopped: %v", path, err)
+		}
+	}()
+	return nil
+}
+
+// Serve accepts clients until the listener fails or the server is closed
+func (s *Server) Serve(listener net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.lock.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		if !s.addConn(conn) {
+			_ = conn.Close()
+			return nil
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting clients and disconnects all clients. The Unix socket file is removed by the listener.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) addConn(conn net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = true
+	return true
+}
+
+func (s *Server) handle(conn net.Conn) {
+	// Operations of disconnected clients are aborted
+	baseCtx := s.BaseContext
+	if baseCtx == nil {
+		baseCtx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(baseCtx)
+	var pending sync.WaitGroup
+	defer func() {
+		cancel()
+		pending.Wait()
+		_ = conn.Close()
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+
+	var writeLock sync.Mutex
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var req request
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			resp := s.execute(ctx, &req)
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			if err := encoder.Encode(resp); err != nil {
+				log.Debugf("Failed to send I2C broker response: %v", err)
+			}
+		}()
+	}
+}
+
+func (s *Server) execute(ctx context.Context, req *request) *response {
+	bus := ft260.ContextBus(s.Bus)
+	resp := &response{ID: req.ID}
+	if req.ReadSize < 0 || req.ReadSize > MaxReadSize {
+		resp.setError(fmt.Errorf("Invalid I2C broker read size %v (must be 0..%v)", req.ReadSize, MaxReadSize))
+		return resp
+	}
+	var err error
+	switch req.Op {
+	case opWrite:
+		err = bus.I2cWriteContext(ctx, req.Addr, req.Data...)
+	case opRead:
+		resp.Data = make([]byte, req.ReadSize)
+		err = bus.I2cReadContext(ctx, req.Addr, resp.Data)
+	case opWriteRead:
+		resp.Data = make([]byte, req.ReadSize)
+		err = bus.I2cWriteReadContext(ctx, req.Addr, req.Data, resp.Data)
+	case opGet:
+		resp.Data, err = bus.I2cGetContext(ctx, req.Addr, req.Register, req.ReadSize)
+	default:
+		err = fmt.Errorf("Unknown I2C broker operation %q", req.Op)
+	}
+	if err != nil {
+		resp.Data = nil
+		resp.setError(err)
+	}
+	return resp
+}
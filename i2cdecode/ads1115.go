@@ -0,0 +1,87 @@
+package i2cdecode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/antongulenko/tank/ads1115"
+)
+
+var (
+	ads1115Mux        = []string{"MUX_01", "MUX_03", "MUX_13", "MUX_23", "MUX_0GND", "MUX_1GND", "MUX_2GND", "MUX_3GND"}
+	ads1115Pga        = []string{"PGA_6V", "PGA_4V", "PGA_2V", "PGA_1V", "PGA_0_5V", "PGA_0_25V", "PGA_0_25V", "PGA_0_25V"}
+	ads1115FullScale  = []float64{ads1115.CONVERT_6V, ads1115.CONVERT_4V, ads1115.CONVERT_2V, ads1115.CONVERT_1V, ads1115.CONVERT_0_5V, ads1115.CONVERT_0_25V, ads1115.CONVERT_0_25V, ads1115.CONVERT_0_25V}
+	ads1115DataRate   = []string{"DR_8", "DR_16", "DR_32", "DR_64", "DR_128", "DR_250", "DR_475", "DR_860"}
+	ads1115Registers  = []string{"CONVERSION", "CONFIG", "LO_THRESH", "HI_THRESH"}
+	ads1115CompQueues = []string{"COMP_QUE_1", "COMP_QUE_2", "COMP_QUE_4", ""}
+)
+
+// ADS1115 decodes the 16 bit registers of the ADS1115 ADC. Conversion results are converted to voltages
+// using the PGA setting of the last decoded CONFIG value.
+type ADS1115 struct {
+	config uint16
+}
+
+func NewADS1115() *ADS1115 {
+	return &ADS1115{
+		config: ads1115.CONFIG_PGA_2V, // Default value after reset
+	}
+}
+
+func (d *ADS1115) Name() string {
+	return "ADS1115"
+}
+
+func (d *ADS1115) Decode(register byte, values []byte) ([]string, byte) {
+	// The register pointer does not change, repeated reads return the same register
+	register &= 0x03
+	var result []string
+	for ; len(values) >= 2; values = values[2:] {
+		result = append(result, d.describeRegister(register, uint16(values[0])<<8|uint16(values[1])))
+	}
+	if len(values) > 0 {
+		result = append(result, fmt.Sprintf("%v partial 0x%02x", ads1115Registers[register], values[0]))
+	}
+	return result, register
+}
+
+func (d *ADS1115) describeRegister(register byte, value uint16) string {
+	switch register {
+	case ads1115.REG_CONVERSION:
+		volt := float64(int16(value)) * ads1115FullScale[d.config>>9&0x07]
+		return fmt.Sprintf("CONVERSION %v (%.4gV)", int16(value), volt)
+	case ads1115.REG_CONFIG:
+		d.config = value
+		return "CONFIG " + describeAds1115Config(value)
+	default:
+		return fmt.Sprintf("%v %v", ads1115Registers[register], int16(value))
+	}
+}
+
+func describeAds1115Config(config uint16) string {
+	var parts []string
+	if config&ads1115.CONFIG_OS != 0 {
+		parts = append(parts, "OS")
+	}
+	parts = append(parts, ads1115Mux[config>>12&0x07], ads1115Pga[config>>9&0x07])
+	if config&ads1115.CONFIG_MODE != 0 {
+		parts = append(parts, "MODE")
+	}
+	parts = append(parts, ads1115DataRate[config>>5&0x07])
+	for _, flag := range []struct {
+		bit  uint16
+		name string
+	}{
+		{ads1115.CONFIG_COMP_MODE, "COMP_MODE"},
+		{ads1115.CONFIG_COMP_POL, "COMP_POL"},
+		{ads1115.CONFIG_COMP_LAT, "COMP_LAT"},
+	} {
+		if config&flag.bit != 0 {
+			parts = append(parts, flag.name)
+		}
+	}
+	if queue := ads1115CompQueues[config&0x03]; queue != "" {
+		parts = append(parts, queue)
+	}
+	return strings.Join(parts, " ")
+}
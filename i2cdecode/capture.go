@@ -0,0 +1,99 @@
+package i2cdecode
+
+import (
+	"fmt"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+// DecodeCapture reconstructs the I2C transfers from HID reports captured with ft260.CaptureTransport, and decodes them.
+// Failed HID transfers are ignored. Transfers reported as failed through the I2C status are output with the failing status.
+func (d *Decoder) DecodeCapture(records []ft260.CaptureRecord) []string {
+	var c captureDecoder
+	c.decoder = d
+	for i := range records {
+		c.handle(&records[i])
+	}
+	c.flushWrite()
+	return c.lines
+}
+
+type captureDecoder struct {
+	decoder *Decoder
+	lines   []string
+
+	addr      byte
+	writing   bool
+	writeDone bool // STOP was sent, the write is decoded when the I2C status confirms it
+	write     []byte
+	reading   bool
+	read      []byte
+	readStop  bool
+}
+
+func (c *captureDecoder) handle(record *ft260.CaptureRecord) {
+	if record.Error != "" {
+		return
+	}
+	isI2cData := record.ReportID >= ft260.ReportID_I2CInOut && record.ReportID <= ft260.ReportID_I2CInOut_Max
+	switch {
+	case record.Dir == ft260.CaptureWrite && !record.Feature && isI2cData:
+		var op ft260.OperationI2cWrite
+		if op.Unmarshall(record.Data) != nil {
+			return
+		}
+		if op.Condition&ft260.I2C_MasterStart != 0 {
+			c.flushWrite()
+			c.reading = false
+			c.addr = op.SlaveAddr
+			c.writing = true
+		}
+		c.write = append(c.write, op.Payload...)
+		c.writeDone = op.Condition&ft260.I2C_MasterStop != 0
+	case record.Dir == ft260.CaptureWrite && !record.Feature && record.ReportID == ft260.ReportID_I2CRead:
+		var op ft260.OperationI2cRead
+		if op.Unmarshall(record.Data) != nil {
+			return
+		}
+		if op.Condition&ft260.I2C_MasterStart != 0 {
+			// A write without STOP before a repeated start sets the register pointer
+			c.flushWrite()
+			c.addr = op.SlaveAddr
+			c.reading = true
+			c.read = nil
+		}
+		c.readStop = op.Condition&ft260.I2C_MasterStop != 0
+	case record.Dir == ft260.CaptureRead && !record.Feature && isI2cData && c.reading:
+		if len(record.Data) == 0 || len(record.Data) < int(record.Data[0])+1 {
+			return
+		}
+		c.read = append(c.read, record.Data[1:1+record.Data[0]]...)
+		if c.readStop {
+			c.lines = append(c.lines, c.decoder.Read(c.addr, c.read)...)
+			c.reading = false
+			c.read = nil
+		}
+	case record.Dir == ft260.CaptureRead && record.Feature && record.ReportID == ft260.ReportID_I2CStatus:
+		var status ft260.ReportI2cStatus
+		if len(record.Data) < status.ReportLen() || status.Unmarshall(record.Data) != nil {
+			return
+		}
+		const errorBits = ft260.I2C_StatusError | ft260.I2C_StatusNoSlaveAck | ft260.I2C_StatusNoDataAck | ft260.I2C_StatusArbitrationLost
+		if status.BusStatus&ft260.I2C_StatusControllerBusy == 0 && status.BusStatus&errorBits != 0 && (c.writing || c.reading) {
+			c.lines = append(c.lines, fmt.Sprintf("%v: transfer failed, bus status %02x", c.decoder.name(c.addr), status.BusStatus))
+			c.writing, c.writeDone, c.write = false, false, nil
+			c.reading, c.read = false, nil
+		} else if status.BusStatus&ft260.I2C_StatusControllerBusy == 0 && c.writeDone {
+			c.flushWrite()
+		}
+	}
+}
+
+func (c *captureDecoder) flushWrite() {
+	if c.writing {
+		c.lines = append(c.lines, c.decoder.Write(c.addr, c.write)...)
+		c.writing = false
+		c.writeDone = false
+		c.write = nil
+	}
+}
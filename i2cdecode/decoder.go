@@ -0,0 +1,179 @@
+package i2cdecode
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/antongulenko/tank/ft260"
+	log "github.com/sirupsen/logrus"
+)
+
+// Device decodes the register values of one I2C device. Implementations can be stateful (e.g. track the auto-increment setting).
+type Device interface {
+	Name() string
+
+	// Describes the values of consecutive register accesses, starting at the given register.
+	// Returns the register pointer after the access.
+	Decode(register byte, values []byte) (descriptions []string, next byte)
+}
+
+// Decoder translates I2C transfers into device-level descriptions, based on the devices attached to the I2C addresses.
+// It tracks the register pointer of every device: the first written byte sets the pointer, following bytes are register values.
+type Decoder struct {
+	lock     sync.Mutex
+	devices  map[byte]Device
+	pointers map[byte]byte
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{
+		devices:  make(map[byte]Device),
+		pointers: make(map[byte]byte),
+	}
+}
+
+func (d *Decoder) Attach(addr byte, device Device) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.devices[addr] = device
+}
+
+// Write decodes data written to the given I2C address
+func (d *Decoder) Write(addr byte, data []byte) []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	device, ok := d.devices[addr]
+	if !ok {
+		return []string{fmt.Sprintf("0x%02x: write %x", addr, data)}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	register := data[0]
+	descriptions, next := device.Decode(register, data[1:])
+	d.pointers[addr] = next
+	return prefix(fmt.Sprintf("%v@0x%02x: ", device.Name(), addr), descriptions)
+}
+
+// Read decodes data read from the given I2C address, starting at the current register pointer of the device
+func (d *Decoder) Read(addr byte, data []byte) []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	device, ok := d.devices[addr]
+	if !ok {
+		return []string{fmt.Sprintf("0x%02x: read %x", addr, data)}
+	}
+	descriptions, next := device.Decode(d.pointers[addr], data)
+	d.pointers[addr] = next
+	return prefix(fmt.Sprintf("%v@0x%02x: read ", device.Name(), addr), descriptions)
+}
+
+func (d *Decoder) name(addr byte) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if device, ok := d.devices[addr]; ok {
+		return fmt.Sprintf("%v@0x%02x", device.Name(), addr)
+	}
+	return fmt.Sprintf("0x%02x", addr)
+}
+
+func prefix(p string, lines []string) []string {
+	for i, line := range lines {
+		lines[i] = p + line
+	}
+	return lines
+}
+
+// Bus forwards all operations to the wrapped I2cBus and outputs the decoded traffic
+type Bus struct {
+	ft260.I2cBus
+	Decoder *Decoder
+	Output  func(line string) // Defaults to logging every line
+}
+
+func NewBus(bus ft260.I2cBus, decoder *Decoder) *Bus {
+	return &Bus{
+		I2cBus:  bus,
+		Decoder: decoder,
+	}
+}
+
+func (b *Bus) I2cWrite(addr byte, data ...byte) error {
+	return b.I2cWriteContext(context.Background(), addr, data...)
+}
+
+func (b *Bus) I2cRead(addr byte, data []byte) error {
+	return b.I2cReadContext(context.Background(), addr, data)
+}
+
+func (b *Bus) I2cWriteRead(addr byte, out, in []byte) error {
+	return b.I2cWriteReadContext(context.Background(), addr, out, in)
+}
+
+func (b *Bus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	return b.I2cGetContext(context.Background(), addr, registerAddr, size)
+}
+
+func (b *Bus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	err := ft260.ContextBus(b.I2cBus).I2cWriteContext(ctx, addr, data...)
+	b.output(addr, "write", err, func() []string {
+		return b.Decoder.Write(addr, data)
+	})
+	return err
+}
+
+func (b *Bus) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
+	err := ft260.ContextBus(b.I2cBus).I2cReadContext(ctx, addr, data)
+	b.output(addr, "read", err, func() []string {
+		return b.Decoder.Read(addr, data)
+	})
+	return err
+}
+
+func (b *Bus) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
+	err := ft260.ContextBus(b.I2cBus).I2cWriteReadContext(ctx, addr, out, in)
+	b.output(addr, "write/read", err, func() []string {
+		return append(b.Decoder.Write(addr, out), b.Decoder.Read(addr, in)...)
+	})
+	return err
+}
+
+func (b *Bus) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error) {
+	result, err := ft260.ContextBus(b.I2cBus).I2cGetContext(ctx, addr, registerAddr, size)
+	b.output(addr, "get", err, func() []string {
+		return append(b.Decoder.Write(addr, []byte{registerAddr}), b.Decoder.Read(addr, result)...)
+	})
+	return result, err
+}
+
+func (b *Bus) output(addr byte, operation string, err error, decode func() []string) {
+	var lines []string
+	if err != nil {
+		lines = []string{fmt.Sprintf("%v: %v failed: %v", b.Decoder.name(addr), operation, err)}
+	} else {
+		lines = decode()
+	}
+	for _, line := range lines {
+		if b.Output != nil {
+			b.Output(line)
+		} else {
+			log.Println(line)
+		}
+	}
+}
+
+// Formats the names of all set bits. names[i] is the name of bit i, empty names are ignored.
+func formatFlags(value byte, names []string) string {
+	var set []string
+	for i, name := range names {
+		if name != "" && value&(1<<i) != 0 {
+			set = append(set, name)
+		}
+	}
+	if len(set) == 0 {
+		return "0"
+	}
+	return strings.Join(set, "|")
+}
@@ -0,0 +1,83 @@
+package i2cdecode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/antongulenko/tank/ads1115"
+	"github.com/antongulenko/tank/ft260"
+	"github.com/antongulenko/tank/mcp23017"
+	"github.com/antongulenko/tank/pca9685"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDecoder() *Decoder {
+	d := NewDecoder()
+	d.Attach(0x40, NewPCA9685())
+	d.Attach(0x48, NewADS1115())
+	d.Attach(0x20, NewMCP23017())
+	return d
+}
+
+func Test_decode_pca9685(t *testing.T) {
+	a := assert.New(t)
+	d := newTestDecoder()
+	a.Equal([]string{"PCA9685@0x40: MODE1 ALLCALL|AI"}, d.Write(0x40, []byte{pca9685.MODE1, pca9685.MODE1_ALLCALL | pca9685.MODE1_AI}))
+
+	a.Equal([]string{
+		"PCA9685@0x40: LED3 on=0 off=2048 (50%)",
+		"PCA9685@0x40: LED4 on=0 off=0 (full on)",
+		"PCA9685@0x40: LED5_ON_L 0x01",
+	}, d.Write(0x40, []byte{pca9685.LED3, 0, 0, 0, 8, 0, pca9685.FULL_ON_BIT, 0, 0, 1}))
+
+	a.Equal([]string{"PCA9685@0x40: read LED5_ON_H 0x00"}, d.Read(0x40, []byte{0}))
+	a.Equal([]string{"PCA9685@0x40: PRE_SCALE 121 (50.03 Hz)"}, d.Write(0x40, []byte{pca9685.PRE_SCALE, 121}))
+	a.Equal([]string{"PCA9685@0x40: ALL_LEDS on=0 off=0 (full off)"}, d.Write(0x40, []byte{pca9685.ALL_LEDS, 0, 0, 0, pca9685.FULL_OFF_BIT}))
+
+	// Without auto-increment, all values are written to the same register
+	d.Write(0x40, []byte{pca9685.MODE1, pca9685.MODE1_ALLCALL})
+	a.Equal([]string{"PCA9685@0x40: LED0_ON_L 0x01", "PCA9685@0x40: LED0_ON_L 0x02"}, d.Write(0x40, []byte{pca9685.LED0, 1, 2}))
+}
+
+func Test_decode_ads1115_mcp23017(t *testing.T) {
+	a := assert.New(t)
+	d := newTestDecoder()
+	config := ads1115.CONFIG_MUX_03 | ads1115.CONFIG_DR_32 | ads1115.CONFIG_PGA_6V | ads1115.CONFIG_COMP_QUE_OFF
+	a.Equal([]string{"ADS1115@0x48: CONFIG MUX_03 PGA_6V DR_32"}, d.Write(0x48, []byte{ads1115.REG_CONFIG, byte(config >> 8), byte(config)}))
+	a.Empty(d.Write(0x48, []byte{ads1115.REG_CONVERSION}))
+	a.Equal([]string{"ADS1115@0x48: read CONVERSION 16384 (3.072V)"}, d.Read(0x48, []byte{0x40, 0x00}))
+
+	a.Equal([]string{"MCP23017@0x20: IOCON INTPOL|HAEN"}, d.Write(0x20, []byte{mcp23017.IOCON_PAIRED, mcp23017.IOCON_BIT_INTPOL | mcp23017.IOCON_BIT_HAEN}))
+	a.Equal([]string{"MCP23017@0x20: GPIO_A 0b11110000", "MCP23017@0x20: GPIO_B 0b00001111"}, d.Write(0x20, []byte{mcp23017.GPIO_PAIRED, 0xF0, 0x0F}))
+
+	a.Equal([]string{"0x50: write 0102"}, d.Write(0x50, []byte{1, 2}))
+}
+
+func Test_decode_bus_and_capture(t *testing.T) {
+	a := assert.New(t)
+	sim := ft260.NewSimulator()
+	sim.Attach(0x40, new(ft260.RegisterSlave))
+	var capture bytes.Buffer
+	dev := ft260.NewFt260(ft260.NewCaptureTransport(sim, &capture))
+
+	var lines []string
+	bus := NewBus(dev, newTestDecoder())
+	bus.Output = func(line string) {
+		lines = append(lines, line)
+	}
+	a.NoError(bus.I2cWrite(0x40, pca9685.LED1, 0, 0, 0, 8))
+	_, err := bus.I2cGet(0x40, pca9685.LED1_OFF_H, 1)
+	a.NoError(err)
+	a.Error(bus.I2cWrite(0x41, 0))
+	a.Equal("PCA9685@0x40: LED1 on=0 off=2048 (50%)", lines[0])
+	a.Equal("PCA9685@0x40: read LED1_OFF_H 0x08", lines[1])
+	a.Contains(lines[2], "0x41: write failed")
+
+	records, err := ft260.ReadCapture(&capture)
+	a.NoError(err)
+	a.Equal([]string{
+		"PCA9685@0x40: LED1 on=0 off=2048 (50%)",
+		"PCA9685@0x40: read LED1_OFF_H 0x08",
+		"0x41: transfer failed, bus status 26",
+	}, newTestDecoder().DecodeCapture(records))
+}
@@ -0,0 +1,105 @@
+package i2cdecode
+
+import (
+	"fmt"
+
+	"github.com/antongulenko/tank/mcp23017"
+)
+
+const mcp23017LastRegister = mcp23017.OLAT_B_PAIRED
+
+var (
+	mcp23017IoconFlags = []string{"", "INTPOL", "ODR", "HAEN", "DISSLW", "SEQOP", "MIRROR", "BANK"}
+	mcp23017Paired     = map[byte]string{
+		mcp23017.IODIR_A_PAIRED:   "IODIR_A",
+		mcp23017.IODIR_B_PAIRED:   "IODIR_B",
+		mcp23017.IPOL_A_PAIRED:    "IPOL_A",
+		mcp23017.IPOL_B_PAIRED:    "IPOL_B",
+		mcp23017.GPINTEN_A_PAIRED: "GPINTEN_A",
+		mcp23017.GPINTEN_B_PAIRED: "GPINTEN_B",
+		mcp23017.DEFVAL_A_PAIRED:  "DEFVAL_A",
+		mcp23017.DEFVAL_B_PAIRED:  "DEFVAL_B",
+		mcp23017.INTCON_A_PAIRED:  "INTCON_A",
+		mcp23017.INTCON_B_PAIRED:  "INTCON_B",
+		mcp23017.IOCON_PAIRED:     "IOCON",
+		mcp23017.IOCON_PAIRED + 1: "IOCON",
+		mcp23017.GPPU_A_PAIRED:    "GPPU_A",
+		mcp23017.GPPU_B_PAIRED:    "GPPU_B",
+		mcp23017.INTF_A_PAIRED:    "INTF_A",
+		mcp23017.INTF_B_PAIRED:    "INTF_B",
+		mcp23017.INTCAP_A_PAIRED:  "INTCAP_A",
+		mcp23017.INTCAP_B_PAIRED:  "INTCAP_B",
+		mcp23017.GPIO_A_PAIRED:    "GPIO_A",
+		mcp23017.GPIO_B_PAIRED:    "GPIO_B",
+		mcp23017.OLAT_A_PAIRED:    "OLAT_A",
+		mcp23017.OLAT_B_PAIRED:    "OLAT_B",
+	}
+	mcp23017Bank = map[byte]string{
+		mcp23017.IODIR_A_BANK:      "IODIR_A",
+		mcp23017.IPOL_A_BANK:       "IPOL_A",
+		mcp23017.GPINTEN_A_BANK:    "GPINTEN_A",
+		mcp23017.DEFVAL_A_BANK:     "DEFVAL_A",
+		mcp23017.INTCON_A_BANK:     "INTCON_A",
+		mcp23017.IOCON_BANK:        "IOCON",
+		mcp23017.GPPU_A_BANK:       "GPPU_A",
+		mcp23017.INTF_A_BANK:       "INTF_A",
+		mcp23017.INTCAP_A_BANK:     "INTCAP_A",
+		mcp23017.GPIO_A_BANK:       "GPIO_A",
+		mcp23017.OLAT_A_BANK:       "OLAT_A",
+		mcp23017.IODIR_B_BANK:      "IODIR_B",
+		mcp23017.IPOL_B_BANK:       "IPOL_B",
+		mcp23017.GPINTEN_B_BANK:    "GPINTEN_B",
+		mcp23017.DEFVAL_B_BANK:     "DEFVAL_B",
+		mcp23017.INTCON_B_BANK:     "INTCON_B",
+		mcp23017.INTCON_B_BANK + 1: "IOCON",
+		mcp23017.GPPU_B_BANK:       "GPPU_B",
+		mcp23017.INTF_B_BANK:       "INTF_B",
+		mcp23017.INTCAP_B_BANK:     "INTCAP_B",
+		mcp23017.GPIO_B_BANK:       "GPIO_B",
+		mcp23017.OLAT_B_BANK:       "OLAT_B",
+	}
+)
+
+// MCP23017 decodes the registers of the MCP23017 GPIO extender, following the BANK and SEQOP bits of IOCON.
+type MCP23017 struct {
+	iocon byte
+}
+
+func NewMCP23017() *MCP23017 {
+	return new(MCP23017)
+}
+
+func (d *MCP23017) Name() string {
+	return "MCP23017"
+}
+
+func (d *MCP23017) Decode(register byte, values []byte) ([]string, byte) {
+	var result []string
+	for _, value := range values {
+		result = append(result, d.describeRegister(register, value))
+		if d.iocon&mcp23017.IOCON_BIT_SEQOP == 0 {
+			register++
+			if register > mcp23017LastRegister {
+				register = 0
+			}
+		}
+	}
+	return result, register
+}
+
+func (d *MCP23017) describeRegister(register byte, value byte) string {
+	names := mcp23017Paired
+	if d.iocon&mcp23017.IOCON_BIT_BANK != 0 {
+		names = mcp23017Bank
+	}
+	name, ok := names[register]
+	switch {
+	case !ok:
+		return fmt.Sprintf("REG_0x%02x 0x%02x", register, value)
+	case name == "IOCON":
+		d.iocon = value
+		return "IOCON " + formatFlags(value, mcp23017IoconFlags)
+	default:
+		return fmt.Sprintf("%v 0b%08b", name, value)
+	}
+}
@@ -0,0 +1,104 @@
+package i2cdecode
+
+import (
+	"fmt"
+
+	"github.com/antongulenko/tank/pca9685"
+)
+
+var (
+	pca9685Mode1Flags = []string{"ALLCALL", "SUB3", "SUB2", "SUB1", "SLEEP", "AI", "EXTCLK", "RESTART"}
+	pca9685Mode2Flags = []string{"OUTNE0", "OUTNE1", "OUTDRV", "OCH", "INVRT"}
+	pca9685Registers  = map[byte]string{
+		pca9685.MODE1:      "MODE1",
+		pca9685.MODE2:      "MODE2",
+		pca9685.SUBADR1:    "SUBADR1",
+		pca9685.SUBADR2:    "SUBADR2",
+		pca9685.SUBADR3:    "SUBADR3",
+		pca9685.ALLCALLADR: "ALLCALLADR",
+		pca9685.PRE_SCALE:  "PRE_SCALE",
+		pca9685.TEST_MODE:  "TEST_MODE",
+	}
+	pca9685LedRegisters = []string{"ON_L", "ON_H", "OFF_L", "OFF_H"}
+)
+
+// PCA9685 decodes the registers of the PCA9685 PWM controller.
+// Register auto-increment is assumed to be enabled, until MODE1 is accessed.
+type PCA9685 struct {
+	noAutoIncrement bool
+}
+
+func NewPCA9685() *PCA9685 {
+	return new(PCA9685)
+}
+
+func (d *PCA9685) Name() string {
+	return "PCA9685"
+}
+
+func (d *PCA9685) Decode(register byte, values []byte) ([]string, byte) {
+	var result []string
+	for len(values) > 0 {
+		if led, ok := pca9685LedName(register); ok && !d.noAutoIncrement && (register-pca9685.LED0)%pca9685.BYTE_PER_OUTPUT == 0 && len(values) >= pca9685.BYTE_PER_OUTPUT {
+			result = append(result, describePwm(led, values))
+			values = values[pca9685.BYTE_PER_OUTPUT:]
+			register += pca9685.BYTE_PER_OUTPUT
+			continue
+		}
+		result = append(result, d.describeRegister(register, values[0]))
+		values = values[1:]
+		if !d.noAutoIncrement {
+			register++
+		}
+	}
+	return result, register
+}
+
+func (d *PCA9685) describeRegister(register byte, value byte) string {
+	switch register {
+	case pca9685.MODE1:
+		d.noAutoIncrement = value&pca9685.MODE1_AI == 0
+		return "MODE1 " + formatFlags(value, pca9685Mode1Flags)
+	case pca9685.MODE2:
+		return "MODE2 " + formatFlags(value, pca9685Mode2Flags)
+	case pca9685.SUBADR1, pca9685.SUBADR2, pca9685.SUBADR3, pca9685.ALLCALLADR:
+		return fmt.Sprintf("%v 0x%02x (address 0x%02x)", pca9685Registers[register], value, value>>1)
+	case pca9685.PRE_SCALE:
+		freq := float64(pca9685.INTERNAL_OSCILLATOR) / (pca9685.TIMER_RESOLUTION * (float64(value) + 1))
+		return fmt.Sprintf("PRE_SCALE %v (%.4g Hz)", value, freq)
+	}
+	name, ok := pca9685Registers[register]
+	if led, isLed := pca9685LedName(register); isLed {
+		name = led + "_" + pca9685LedRegisters[(register-pca9685.LED0)%pca9685.BYTE_PER_OUTPUT]
+	} else if !ok {
+		name = fmt.Sprintf("REG_0x%02x", register)
+	}
+	return fmt.Sprintf("%v 0x%02x", name, value)
+}
+
+func pca9685LedName(register byte) (string, bool) {
+	switch {
+	case register >= pca9685.LED0 && register <= pca9685.LED15_OFF_H:
+		return fmt.Sprintf("LED%v", (register-pca9685.LED0)/pca9685.BYTE_PER_OUTPUT), true
+	case register >= pca9685.ALL_LEDS && register <= pca9685.ALL_OFF_H:
+		return "ALL_LEDS", true
+	}
+	return "", false
+}
+
+// Describes the 4 registers of one PWM output
+func describePwm(name string, values []byte) string {
+	on := int(values[0]) | int(values[1]&0x0F)<<8
+	off := int(values[2]) | int(values[3]&0x0F)<<8
+	var duty string
+	switch {
+	case values[3]&pca9685.FULL_OFF_BIT != 0:
+		duty = "full off"
+	case values[1]&pca9685.FULL_ON_BIT != 0:
+		duty = "full on"
+	default:
+		onTime := (off - on + pca9685.TIMER_RESOLUTION) % pca9685.TIMER_RESOLUTION
+		duty = fmt.Sprintf("%.3g%%", float64(onTime)/pca9685.TIMER_RESOLUTION*100)
+	}
+	return fmt.Sprintf("%v on=%v off=%v (%v)", name, on, off, duty)
+}
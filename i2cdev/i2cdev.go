@@ -0,0 +1,161 @@
+//go:build linux
+// +build linux
+
+// Package i2cdev implements ft260.I2cBus on top of the Linux i2c-dev interface (/dev/i2c-N),
+// for boards with a native I2C controller.
+package i2cdev
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+// Constants from linux/i2c-dev.h and linux/i2c.h
+const (
+	I2C_RDWR = 0x0707
+	I2C_M_RD = 0x0001
+)
+
+// struct i2c_msg
+type i2cMsg struct {
+	addr  uint16
+	flags uint16
+	len   uint16
+	buf   uintptr
+}
+
+// struct i2c_rdwr_ioctl_data
+type i2cRdwrIoctlData struct {
+	msgs  uintptr
+	nmsgs uint32
+}
+
+type Bus struct {
+	file *os.File
+	lock sync.Mutex
+}
+
+var _ ft260.I2cBus = new(Bus)
+
+// Open opens an i2c-dev character device, e.g. /dev/i2c-1
+func Open(path string) (*Bus, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Bus{
+		file: file,
+	}, nil
+}
+
+func OpenBus(number int) (*Bus, error) {
+	return Open(fmt.Sprintf("/dev/i2c-%v", number))
+}
+
+func (b *Bus) Close() error {
+	return b.file.Close()
+}
+
+func (b *Bus) String() string {
+	return b.file.Name()
+}
+
+func (b *Bus) I2cWrite(addr byte, data ...byte) error {
+	return b.transfer(addr, data, nil)
+}
+
+func (b *Bus) I2cRead(addr byte, data []byte) error {
+	return b.transfer(addr, nil, data)
+}
+
+func (b *Bus) I2cWriteRead(addr byte, out, in []byte) error {
+	return b.transfer(addr, out, in)
+}
+
+func (b *Bus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	receive := make([]byte, size)
+	err := b.I2cWriteRead(addr, []byte{registerAddr}, receive)
+	return receive, err
+}
+
+// Executes a write and/or read as one combined transaction: the read follows the write with a repeated START.
+func (b *Bus) transfer(addr byte, out, in []byte) error {
+	if addr&0x80 != 0 {
+		return fmt.Errorf("Invalid I2C slave address: %02x", addr)
+	}
+	if len(out) > math.MaxUint16 || len(in) > math.MaxUint16 {
+		return fmt.Errorf("I2C message too long (writing %v and reading %v byte, maximum is %v)", len(out), len(in), math.MaxUint16)
+	}
+	msgs := make([]i2cMsg, 0, 2)
+	if len(out) > 0 || len(in) == 0 {
+		msgs = append(msgs, newMsg(addr, 0, out))
+	}
+	if len(in) > 0 {
+		msgs = append(msgs, newMsg(addr, I2C_M_RD, in))
+	}
+	data := i2cRdwrIoctlData{
+		msgs:  uintptr(unsafe.Pointer(&msgs[0])),
+		nmsgs: uint32(len(msgs)),
+	}
+
+	b.lock.Lock()
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, b.file.Fd(), I2C_RDWR, uintptr(unsafe.Pointer(&data)))
+	b.lock.Unlock()
+	runtime.KeepAlive(out)
+	runtime.KeepAlive(in)
+	runtime.KeepAlive(msgs)
+
+	if errno != 0 {
+		return convertError(errno, addr, len(out), len(in))
+	}
+	return nil
+}
+
+func newMsg(addr byte, flags uint16, buf []byte) i2cMsg {
+	msg := i2cMsg{
+		addr:  uint16(addr),
+		flags: flags,
+		len:   uint16(len(buf)),
+	}
+	if len(buf) > 0 {
+		msg.buf = uintptr(unsafe.Pointer(&buf[0]))
+	}
+	return msg
+}
+
+// Translates the error codes documented in Documentation/i2c/fault-codes into ft260.I2cError values,
+// so that code like ft260.I2cScanRange can treat both bus implementations the same way.
+func convertError(errno syscall.Errno, addr byte, numWrite, numRead int) error {
+	desc := fmt.Sprintf("%v, writing %v and reading %v byte from %02x", errno, numWrite, numRead, addr)
+	switch errno {
+	case syscall.ENXIO, syscall.EREMOTEIO:
+		return &ft260.I2cError{
+			BusStatus:            ft260.I2C_StatusError | ft260.I2C_StatusNoSlaveAck,
+			OperationDescription: desc,
+		}
+	case syscall.EAGAIN:
+		return &ft260.I2cError{
+			BusStatus:            ft260.I2C_StatusError | ft260.I2C_StatusArbitrationLost,
+			OperationDescription: desc,
+		}
+	case syscall.EBUSY:
+		return &ft260.I2cError{
+			BusStatus:            ft260.I2C_StatusBusBusy,
+			OperationDescription: desc,
+		}
+	case syscall.ETIMEDOUT:
+		return &ft260.I2cError{
+			TimedOut:             true,
+			OperationDescription: desc,
+		}
+	default:
+		return fmt.Errorf("i2c-dev: %v", desc)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !linux
+// +build !linux
+
+package i2cdev
+
+import (
+	"errors"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+var errUnsupported = errors.New("i2c-dev is only supported on Linux")
+
+type Bus struct {
+}
+
+var _ ft260.I2cBus = new(Bus)
+
+func Open(path string) (*Bus, error) {
+	return nil, errUnsupported
+}
+
+func OpenBus(number int) (*Bus, error) {
+	return nil, errUnsupported
+}
+
+func (b *Bus) Close() error {
+	return errUnsupported
+}
+
+func (b *Bus) I2cWrite(addr byte, data ...byte) error {
+	return errUnsupported
+}
+
+func (b *Bus) I2cRead(addr byte, data []byte) error {
+	return errUnsupported
+}
+
+func (b *Bus) I2cWriteRead(addr byte, out, in []byte) error {
+	return errUnsupported
+}
+
+func (b *Bus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	return nil, errUnsupported
+}
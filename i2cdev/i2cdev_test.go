@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package i2cdev
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/antongulenko/tank/ft260"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertError(t *testing.T) {
+	a := assert.New(t)
+	for _, test := range []struct {
+		errno     syscall.Errno
+		busStatus byte
+		timedOut  bool
+	}{
+		{syscall.ENXIO, ft260.I2C_StatusError | ft260.I2C_StatusNoSlaveAck, false},
+		{syscall.EREMOTEIO, ft260.I2C_StatusError | ft260.I2C_StatusNoSlaveAck, false},
+		{syscall.EAGAIN, ft260.I2C_StatusError | ft260.I2C_StatusArbitrationLost, false},
+		{syscall.EBUSY, ft260.I2C_StatusBusBusy, false},
+		{syscall.ETIMEDOUT, 0, true},
+	} {
+		err := convertError(test.errno, 0x40, 2, 1)
+		if a.IsType(new(ft260.I2cError), err, "errno %v", test.errno) {
+			i2cErr := err.(*ft260.I2cError)
+			a.Equal(test.busStatus, i2cErr.BusStatus, "errno %v", test.errno)
+			a.Equal(test.timedOut, i2cErr.TimedOut, "errno %v", test.errno)
+			a.Contains(i2cErr.OperationDescription, "40")
+		}
+	}
+
+	err := convertError(syscall.EINVAL, 0x40, 2, 1)
+	var i2cErr *ft260.I2cError
+	a.False(errors.As(err, &i2cErr))
+	a.Contains(err.Error(), "i2c-dev")
+}
+
+func TestMessageLength(t *testing.T) {
+	a := assert.New(t)
+	bus := new(Bus)
+	a.Error(bus.I2cWrite(0x40, make([]byte, 65536)...))
+	a.Error(bus.I2cRead(0x40, make([]byte, 65536)))
+	a.Error(bus.I2cWriteRead(0x40, []byte{0x00}, make([]byte, 70000)))
+	a.Error(bus.I2cWrite(0x80, 0x00))
+}
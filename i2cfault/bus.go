@@ -0,0 +1,256 @@
+package i2cfault
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+type Fault int
+
+const (
+	FaultNone Fault = iota
+	FaultNoSlaveAck
+	FaultNoDataAck
+	FaultArbitrationLost
+	FaultBusBusy
+	FaultTimeout
+	FaultCorruptRead // The operation is executed, but one bit of the read data is flipped
+	numFaults
+)
+
+var faultNames = [numFaults]string{"none", "no slave ack", "no data ack", "arbitration lost", "bus busy", "timeout", "corrupt read"}
+
+func (f Fault) String() string {
+	if f < 0 || f >= numFaults {
+		return fmt.Sprintf("Fault(%v)", int(f))
+	}
+	return faultNames[f]
+}
+
+// I2cError returns the I2C error the FT260 reports for the fault, or nil for FaultNone and FaultCorruptRead
+func (f Fault) I2cError(operationTime time.Duration) error {
+	var status byte
+	switch f {
+	case FaultNoSlaveAck:
+		status = ft260.I2C_StatusControllerIdle | ft260.I2C_StatusError | ft260.I2C_StatusNoSlaveAck
+	case FaultNoDataAck:
+		status = ft260.I2C_StatusControllerIdle | ft260.I2C_StatusError | ft260.I2C_StatusNoDataAck
+	case FaultArbitrationLost:
+		status = ft260.I2C_StatusControllerIdle | ft260.I2C_StatusError | ft260.I2C_StatusArbitrationLost
+	case FaultBusBusy:
+		status = ft260.I2C_StatusError | ft260.I2C_StatusBusBusy
+	case FaultTimeout:
+		return &ft260.I2cError{TimedOut: true, BusStatus: ft260.I2C_StatusControllerBusy, OperationTime: operationTime}
+	default:
+		return nil
+	}
+	return &ft260.I2cError{BusStatus: status, OperationTime: operationTime}
+}
+
+// Rates configures the probabilities (0..1) of random faults for every operation, and added latency
+type Rates struct {
+	NoSlaveAck      float64
+	NoDataAck       float64
+	ArbitrationLost float64
+	BusBusy         float64
+	Timeout         float64
+	CorruptRead     float64
+
+	Latency       time.Duration // Added to every operation
+	LatencyJitter time.Duration // Random additional latency up to this value
+}
+
+func (r *Rates) rate(fault Fault) float64 {
+	switch fault {
+	case FaultNoSlaveAck:
+		return r.NoSlaveAck
+	case FaultNoDataAck:
+		return r.NoDataAck
+	case FaultArbitrationLost:
+		return r.ArbitrationLost
+	case FaultBusBusy:
+		return r.BusBusy
+	case FaultTimeout:
+		return r.Timeout
+	case FaultCorruptRead:
+		return r.CorruptRead
+	}
+	return 0
+}
+
+// Bus forwards all operations to the wrapped I2cBus, but injects faults. Scripted faults of an address take precedence
+// over the random faults configured in AddrRates or Rates. Failed operations are not forwarded to the wrapped bus.
+type Bus struct {
+	ft260.I2cBus
+
+	Rates     Rates
+	AddrRates map[byte]Rates // Overrides Rates for individual addresses
+
+	// Time spent in operations failing with FaultTimeout
+	TimeoutDelay time.Duration
+
+	lock     sync.Mutex
+	rand     *rand.Rand
+	script   map[byte][]Fault
+	injected map[Fault]int
+}
+
+func NewBus(bus ft260.I2cBus, seed int64) *Bus {
+	return &Bus{
+		I2cBus:    bus,
+		AddrRates: make(map[byte]Rates),
+		rand:      rand.New(rand.NewSource(seed)),
+		script:    make(map[byte][]Fault),
+		injected:  make(map[Fault]int),
+	}
+}
+
+// Script queues faults for the next operations on the given address, one fault per operation.
+// FaultNone lets an operation pass without fault.
+func (b *Bus) Script(addr byte, faults ...Fault) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.script[addr] = append(b.script[addr], faults...)
+}
+
+// Returns the number of scripted faults that were not yet injected
+func (b *Bus) Scripted(addr byte) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.script[addr])
+}
+
+// Returns the number of injected faults, by fault type
+func (b *Bus) Injected() map[Fault]int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	result := make(map[Fault]int, len(b.injected))
+	for fault, count := range b.injected {
+		result[fault] = count
+	}
+	return result
+}
+
+func (b *Bus) I2cWrite(addr byte, data ...byte) error {
+	return b.I2cWriteContext(context.Background(), addr, data...)
+}
+
+func (b *Bus) I2cRead(addr byte, data []byte) error {
+	return b.I2cReadContext(context.Background(), addr, data)
+}
+
+func (b *Bus) I2cWriteRead(addr byte, out, in []byte) error {
+	return b.I2cWriteReadContext(context.Background(), addr, out, in)
+}
+
+func (b *Bus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	return b.I2cGetContext(context.Background(), addr, registerAddr, size)
+}
+
+func (b *Bus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	if _, err := b.inject(ctx, addr); err != nil {
+		return err
+	}
+	return ft260.ContextBus(b.I2cBus).I2cWriteContext(ctx, addr, data...)
+}
+
+func (b *Bus) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
+	fault, err := b.inject(ctx, addr)
+	if err != nil {
+		return err
+	}
+	err = ft260.ContextBus(b.I2cBus).I2cReadContext(ctx, addr, data)
+	if err == nil {
+		b.corrupt(fault, data)
+	}
+	return err
+}
+
+func (b *Bus) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
+	fault, err := b.inject(ctx, addr)
+	if err != nil {
+		return err
+	}
+	err = ft260.ContextBus(b.I2cBus).I2cWriteReadContext(ctx, addr, out, in)
+	if err == nil {
+		b.corrupt(fault, in)
+	}
+	return err
+}
+
+func (b *Bus) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error) {
+	fault, err := b.inject(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	result, err := ft260.ContextBus(b.I2cBus).I2cGetContext(ctx, addr, registerAddr, size)
+	if err == nil {
+		b.corrupt(fault, result)
+	}
+	return result, err
+}
+
+// Applies the latency and selects the fault for the next operation. Returns an error, if the operation should fail.
+func (b *Bus) inject(ctx context.Context, addr byte) (Fault, error) {
+	start := time.Now()
+	fault, latency := b.nextFault(addr)
+	if fault == FaultTimeout {
+		latency += b.TimeoutDelay
+	}
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return FaultNone, ctx.Err()
+		}
+	}
+	return fault, fault.I2cError(time.Since(start))
+}
+
+func (b *Bus) nextFault(addr byte) (Fault, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	rates, ok := b.AddrRates[addr]
+	if !ok {
+		rates = b.Rates
+	}
+	latency := rates.Latency
+	if rates.LatencyJitter > 0 {
+		latency += time.Duration(b.rand.Int63n(int64(rates.LatencyJitter)))
+	}
+
+	fault := FaultNone
+	if script := b.script[addr]; len(script) > 0 {
+		fault = script[0]
+		b.script[addr] = script[1:]
+	} else {
+		r := b.rand.Float64()
+		for f := FaultNone + 1; f < numFaults; f++ {
+			r -= rates.rate(f)
+			if r < 0 {
+				fault = f
+				break
+			}
+		}
+	}
+	if fault != FaultNone {
+		b.injected[fault]++
+	}
+	return fault, latency
+}
+
+func (b *Bus) corrupt(fault Fault, data []byte) {
+	if fault != FaultCorruptRead || len(data) == 0 {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	data[b.rand.Intn(len(data))] ^= 1 << uint(b.rand.Intn(8))
+}
@@ -0,0 +1,69 @@
+package i2cfault
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/tank/ft260"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBus() (*Bus, *ft260.RegisterSlave) {
+	sim := ft260.NewSimulator()
+	slave := new(ft260.RegisterSlave)
+	sim.Attach(0x40, slave)
+	return NewBus(ft260.NewFt260(sim), 1), slave
+}
+
+func Test_scripted_faults(t *testing.T) {
+	a := assert.New(t)
+	bus, _ := newTestBus()
+
+	// NACKs look like missing slaves, other errors abort the scan
+	bus.Script(0x40, FaultNoSlaveAck)
+	slaves, err := ft260.I2cScanRange(bus, 0x40, 0x41)
+	a.NoError(err)
+	a.Empty(slaves)
+	bus.Script(0x40, FaultBusBusy)
+	_, err = ft260.I2cScanRange(bus, 0x40, 0x41)
+	var i2cErr *ft260.I2cError
+	if a.True(errors.As(err, &i2cErr)) {
+		a.NotZero(i2cErr.BusStatus & ft260.I2C_StatusBusBusy)
+	}
+	slaves, err = ft260.I2cScanRange(bus, 0x40, 0x41)
+	a.NoError(err)
+	a.Equal([]byte{0x40}, slaves)
+
+	bus.Script(0x40, FaultNone, FaultTimeout)
+	a.NoError(bus.I2cWrite(0x40, 0, 0xF0))
+	err = bus.I2cWrite(0x40, 0, 0xF0)
+	if a.True(errors.As(err, &i2cErr)) {
+		a.True(i2cErr.TimedOut)
+	}
+
+	bus.Script(0x40, FaultCorruptRead)
+	data, err := bus.I2cGet(0x40, 0, 1)
+	a.NoError(err)
+	a.Len(data, 1)
+	a.NotEqual(byte(0xF0), data[0])
+	a.Equal(0, bus.Scripted(0x40))
+	a.Equal(map[Fault]int{FaultNoSlaveAck: 1, FaultBusBusy: 1, FaultTimeout: 1, FaultCorruptRead: 1}, bus.Injected())
+}
+
+func Test_random_faults(t *testing.T) {
+	a := assert.New(t)
+	bus, _ := newTestBus()
+	bus.Rates.NoDataAck = 1
+	bus.AddrRates[0x41] = Rates{Latency: time.Second}
+
+	for i := 0; i < 3; i++ {
+		a.Error(bus.I2cWrite(0x40, 0))
+	}
+	a.Equal(map[Fault]int{FaultNoDataAck: 3}, bus.Injected())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	a.Equal(context.DeadlineExceeded, bus.I2cWriteContext(ctx, 0x41, 0))
+}
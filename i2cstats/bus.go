@@ -0,0 +1,65 @@
+package i2cstats
+
+import (
+	"context"
+	"time"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+// Bus forwards all operations to the wrapped I2cBus and records them in Stats
+type Bus struct {
+	ft260.I2cBus
+	Stats *Stats
+}
+
+func NewBus(bus ft260.I2cBus, stats *Stats) *Bus {
+	return &Bus{
+		I2cBus: bus,
+		Stats:  stats,
+	}
+}
+
+func (b *Bus) I2cWrite(addr byte, data ...byte) error {
+	return b.I2cWriteContext(context.Background(), addr, data...)
+}
+
+func (b *Bus) I2cRead(addr byte, data []byte) error {
+	return b.I2cReadContext(context.Background(), addr, data)
+}
+
+func (b *Bus) I2cWriteRead(addr byte, out, in []byte) error {
+	return b.I2cWriteReadContext(context.Background(), addr, out, in)
+}
+
+func (b *Bus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	return b.I2cGetContext(context.Background(), addr, registerAddr, size)
+}
+
+func (b *Bus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	start := time.Now()
+	err := ft260.ContextBus(b.I2cBus).I2cWriteContext(ctx, addr, data...)
+	b.Stats.Record("write", addr, len(data), 0, time.Since(start), err)
+	return err
+}
+
+func (b *Bus) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
+	start := time.Now()
+	err := ft260.ContextBus(b.I2cBus).I2cReadContext(ctx, addr, data)
+	b.Stats.Record("read", addr, 0, len(data), time.Since(start), err)
+	return err
+}
+
+func (b *Bus) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
+	start := time.Now()
+	err := ft260.ContextBus(b.I2cBus).I2cWriteReadContext(ctx, addr, out, in)
+	b.Stats.Record("write/read", addr, len(out), len(in), time.Since(start), err)
+	return err
+}
+
+func (b *Bus) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error) {
+	start := time.Now()
+	result, err := ft260.ContextBus(b.I2cBus).I2cGetContext(ctx, addr, registerAddr, size)
+	b.Stats.Record("get", addr, 1, len(result), time.Since(start), err)
+	return result, err
+}
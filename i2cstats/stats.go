@@ -0,0 +1,280 @@
+package i2cstats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+type ErrorKind int
+
+const (
+	ErrorNoSlaveAck ErrorKind = iota
+	ErrorNoDataAck
+	ErrorArbitrationLost
+	ErrorTimeout
+	ErrorOther
+	numErrorKinds
+)
+
+var errorKindNames = [numErrorKinds]string{"no slave ack", "no data ack", "arbitration lost", "timeout", "other"}
+
+func (k ErrorKind) String() string {
+	if k < 0 || k >= numErrorKinds {
+		return fmt.Sprintf("ErrorKind(%v)", int(k))
+	}
+	return errorKindNames[k]
+}
+
+// ClassifyError returns the kind of a failed I2C operation, based on the ft260.I2cError bus status
+func ClassifyError(err error) ErrorKind {
+	var i2cErr *ft260.I2cError
+	if errors.As(err, &i2cErr) {
+		switch {
+		case i2cErr.TimedOut:
+			return ErrorTimeout
+		case i2cErr.BusStatus&ft260.I2C_StatusNoSlaveAck != 0:
+			return ErrorNoSlaveAck
+		case i2cErr.BusStatus&ft260.I2C_StatusNoDataAck != 0:
+			return ErrorNoDataAck
+		case i2cErr.BusStatus&ft260.I2C_StatusArbitrationLost != 0:
+			return ErrorArbitrationLost
+		}
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTimeout
+	}
+	return ErrorOther
+}
+
+// Upper bounds of the latency histogram buckets. Longer latencies are counted in an additional overflow bucket.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	1 * time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond,
+}
+
+type Histogram struct {
+	Counts []uint64 // One count per entry in LatencyBuckets, plus the overflow bucket
+	Count  uint64
+	Sum    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+func (h *Histogram) Add(latency time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+	h.Counts[sort.Search(len(LatencyBuckets), func(i int) bool {
+		return latency <= LatencyBuckets[i]
+	})]++
+	if h.Count == 0 || latency < h.Min {
+		h.Min = latency
+	}
+	if latency > h.Max {
+		h.Max = latency
+	}
+	h.Count++
+	h.Sum += latency
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket containing the given quantile (0..1), or Max for the overflow bucket
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	if rank >= h.Count {
+		rank = h.Count - 1
+	}
+	var seen uint64
+	for i, count := range h.Counts {
+		seen += count
+		if seen > rank {
+			if i < len(LatencyBuckets) && LatencyBuckets[i] < h.Max {
+				return LatencyBuckets[i]
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+func (h *Histogram) clone() *Histogram {
+	result := *h
+	result.Counts = append([]uint64(nil), h.Counts...)
+	return &result
+}
+
+type QueueDepth struct {
+	Samples uint64
+	Sum     uint64
+	Max     int
+}
+
+func (q QueueDepth) Mean() float64 {
+	if q.Samples == 0 {
+		return 0
+	}
+	return float64(q.Sum) / float64(q.Samples)
+}
+
+// Snapshot is a copy of the statistics collected since the start or the last reset
+type Snapshot struct {
+	Since        time.Time
+	Operations   map[string]uint64 // By operation: write, read, write/read, get
+	BytesWritten uint64
+	BytesRead    uint64
+	Errors       map[ErrorKind]uint64
+	Latency      map[byte]*Histogram // By I2C address
+	QueueDepth   QueueDepth          // Sampled whenever a request is queued
+}
+
+// Stats collects statistics about I2C operations. It is safe for concurrent use.
+type Stats struct {
+	lock     sync.Mutex
+	snapshot Snapshot
+}
+
+func NewStats() *Stats {
+	s := new(Stats)
+	s.Reset()
+	return s
+}
+
+func (s *Stats) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.snapshot = Snapshot{
+		Since:      time.Now(),
+		Operations: make(map[string]uint64),
+		Errors:     make(map[ErrorKind]uint64),
+		Latency:    make(map[byte]*Histogram),
+	}
+}
+
+// Record adds one I2C operation. The number of read bytes is only counted for successful operations.
+func (s *Stats) Record(operation string, addr byte, written, read int, latency time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.snapshot.Operations[operation]++
+	s.snapshot.BytesWritten += uint64(written)
+	if err != nil {
+		s.snapshot.Errors[ClassifyError(err)]++
+	} else {
+		s.snapshot.BytesRead += uint64(read)
+	}
+	histogram, ok := s.snapshot.Latency[addr]
+	if !ok {
+		histogram = new(Histogram)
+		s.snapshot.Latency[addr] = histogram
+	}
+	histogram.Add(latency)
+}
+
+// RecordQueueDepth adds a sample of the number of requests waiting for the I2C bus
+func (s *Stats) RecordQueueDepth(depth int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	q := &s.snapshot.QueueDepth
+	q.Samples++
+	q.Sum += uint64(depth)
+	if depth > q.Max {
+		q.Max = depth
+	}
+}
+
+func (s *Stats) Snapshot() Snapshot {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := s.snapshot
+	result.Operations = make(map[string]uint64, len(s.snapshot.Operations))
+	for op, count := range s.snapshot.Operations {
+		result.Operations[op] = count
+	}
+	result.Errors = make(map[ErrorKind]uint64, len(s.snapshot.Errors))
+	for kind, count := range s.snapshot.Errors {
+		result.Errors[kind] = count
+	}
+	result.Latency = make(map[byte]*Histogram, len(s.snapshot.Latency))
+	for addr, histogram := range s.snapshot.Latency {
+		result.Latency[addr] = histogram.clone()
+	}
+	return result
+}
+
+func (s Snapshot) TotalOperations() (result uint64) {
+	for _, count := range s.Operations {
+		result += count
+	}
+	return
+}
+
+func (s Snapshot) TotalErrors() (result uint64) {
+	for _, count := range s.Errors {
+		result += count
+	}
+	return
+}
+
+// Print writes a human readable summary of the statistics
+func (s Snapshot) Print(w io.Writer) error {
+	elapsed := time.Since(s.Since)
+	ops := s.TotalOperations()
+	_, err := fmt.Fprintf(w, "I2C statistics over %v: %v operations (%.1f/s), %v byte written, %v byte read, %v errors\n",
+		elapsed.Round(time.Millisecond), ops, float64(ops)/elapsed.Seconds(), s.BytesWritten, s.BytesRead, s.TotalErrors())
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, op := range sortedKeys(s.Operations) {
+		lines = append(lines, fmt.Sprintf("  %v: %v", op, s.Operations[op]))
+	}
+	for kind := ErrorKind(0); kind < numErrorKinds; kind++ {
+		if count := s.Errors[kind]; count > 0 {
+			lines = append(lines, fmt.Sprintf("  error %v: %v", kind, count))
+		}
+	}
+	addrs := make([]int, 0, len(s.Latency))
+	for addr := range s.Latency {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	for _, addr := range addrs {
+		h := s.Latency[byte(addr)]
+		lines = append(lines, fmt.Sprintf("  0x%02x latency: n=%v min=%v mean=%v p50=%v p99=%v max=%v",
+			addr, h.Count, h.Min, h.Mean(), h.Quantile(0.5), h.Quantile(0.99), h.Max))
+	}
+	if s.QueueDepth.Samples > 0 {
+		lines = append(lines, fmt.Sprintf("  queue depth: mean=%.2f max=%v", s.QueueDepth.Mean(), s.QueueDepth.Max))
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
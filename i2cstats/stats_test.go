@@ -0,0 +1,71 @@
+package i2cstats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/tank/ft260"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_classify_error(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(ErrorNoSlaveAck, ClassifyError(&ft260.I2cError{BusStatus: ft260.I2C_StatusError | ft260.I2C_StatusNoSlaveAck}))
+	a.Equal(ErrorNoDataAck, ClassifyError(&ft260.I2cError{BusStatus: ft260.I2C_StatusError | ft260.I2C_StatusNoDataAck}))
+	a.Equal(ErrorArbitrationLost, ClassifyError(&ft260.I2cError{BusStatus: ft260.I2C_StatusArbitrationLost}))
+	a.Equal(ErrorTimeout, ClassifyError(&ft260.I2cError{TimedOut: true}))
+	a.Equal(ErrorTimeout, ClassifyError(context.DeadlineExceeded))
+	a.Equal(ErrorOther, ClassifyError(errors.New("USB failure")))
+}
+
+func Test_histogram(t *testing.T) {
+	a := assert.New(t)
+	var h Histogram
+	for i := 0; i < 9; i++ {
+		h.Add(200 * time.Microsecond)
+	}
+	h.Add(time.Second)
+	a.Equal(uint64(10), h.Count)
+	a.Equal(uint64(9), h.Counts[1])
+	a.Equal(uint64(1), h.Counts[len(LatencyBuckets)])
+	a.Equal(200*time.Microsecond, h.Min)
+	a.Equal(250*time.Microsecond, h.Quantile(0.5))
+	a.Equal(time.Second, h.Quantile(0.99))
+}
+
+func Test_bus(t *testing.T) {
+	a := assert.New(t)
+	sim := ft260.NewSimulator()
+	sim.Attach(0x40, new(ft260.RegisterSlave))
+	stats := NewStats()
+	bus := NewBus(ft260.NewFt260(sim), stats)
+
+	a.NoError(bus.I2cWrite(0x40, 0, 1, 2))
+	_, err := bus.I2cGet(0x40, 0, 2)
+	a.NoError(err)
+	a.Error(bus.I2cWrite(0x41, 0))
+	stats.RecordQueueDepth(3)
+	stats.RecordQueueDepth(1)
+
+	s := stats.Snapshot()
+	a.Equal(map[string]uint64{"write": 2, "get": 1}, s.Operations)
+	a.Equal(uint64(5), s.BytesWritten)
+	a.Equal(uint64(2), s.BytesRead)
+	a.Equal(map[ErrorKind]uint64{ErrorNoSlaveAck: 1}, s.Errors)
+	a.Equal(uint64(2), s.Latency[0x40].Count)
+	a.Equal(uint64(1), s.Latency[0x41].Count)
+	a.Equal(3, s.QueueDepth.Max)
+	a.Equal(2.0, s.QueueDepth.Mean())
+
+	var buf bytes.Buffer
+	a.NoError(s.Print(&buf))
+	a.Contains(buf.String(), "3 operations")
+	a.Contains(buf.String(), "error no slave ack: 1")
+	a.Contains(buf.String(), "queue depth: mean=2.00 max=3")
+
+	stats.Reset()
+	a.Equal(uint64(0), stats.Snapshot().TotalOperations())
+}
@@ -29,7 +29,11 @@ const (
 	INTCAP_A_BANK
 	GPIO_A_BANK
 	OLAT_A_BANK
-	IODIR_B_BANK
+)
+
+// Port B registers start at 0x10 in the BANK layout
+const (
+	IODIR_B_BANK = byte(0x10 + iota)
 	IPOL_B_BANK
 	GPINTEN_B_BANK
 	DEFVAL_B_BANK
@@ -0,0 +1,139 @@
+package mcp23017
+
+// Register indexes within one port, in the order of the BANK layout
+const (
+	regIodir = iota
+	regIpol
+	regGpinten
+	regDefval
+	regIntcon
+	regIocon
+	regGppu
+	regIntf
+	regIntcap
+	regGpio
+	regOlat
+	numPortRegisters
+)
+
+// Model is a software model of the MCP23017 registers. It implements the I2cSlave interface of the ft260.Simulator.
+// It supports the BANK and PAIRED register layouts and the sequential and byte modes of IOCON_BIT_SEQOP.
+// Interrupts are not modelled, INTF and INTCAP always read as zero.
+type Model struct {
+	Registers [2][numPortRegisters]byte // Indexed by port (A, B) and register
+	Pointer   byte
+
+	// Levels of externally driven input pins, by port. Input pins not contained in the Driven mask are pulled up
+	// if enabled in GPPU, and read as zero otherwise.
+	Inputs [2]byte
+	Driven [2]byte
+}
+
+func NewModel() *Model {
+	m := new(Model)
+	m.Reset()
+	return m
+}
+
+// Reset restores the power-on register values
+func (m *Model) Reset() {
+	m.Registers = [2][numPortRegisters]byte{}
+	m.Registers[0][regIodir] = INPUT
+	m.Registers[1][regIodir] = INPUT
+	m.Pointer = 0
+}
+
+func (m *Model) Iocon() byte {
+	return m.Registers[0][regIocon]
+}
+
+// Returns the pin levels of the port (0 for A, 1 for B). Output pins reflect OLAT.
+func (m *Model) Pins(port int) byte {
+	regs := &m.Registers[port]
+	inputs := m.Inputs[port]&m.Driven[port] | regs[regGppu]&^m.Driven[port]
+	return regs[regOlat]&^regs[regIodir] | inputs&regs[regIodir]
+}
+
+func (m *Model) I2cSlaveWrite(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	m.Pointer = data[0]
+	for _, b := range data[1:] {
+		m.writeRegister(m.Pointer, b)
+		m.Pointer = m.next(m.Pointer)
+	}
+	return nil
+}
+
+func (m *Model) I2cSlaveRead(data []byte) error {
+	for i := range data {
+		data[i] = m.readRegister(m.Pointer)
+		m.Pointer = m.next(m.Pointer)
+	}
+	return nil
+}
+
+// Maps a register address to the port and register index, depending on the BANK bit
+func (m *Model) decode(addr byte) (port int, register int, ok bool) {
+	if m.Iocon()&IOCON_BIT_BANK != 0 {
+		port, register = int(addr>>4), int(addr&0x0F)
+		ok = port < 2 && register < numPortRegisters
+	} else {
+		port, register = int(addr%2), int(addr/2)
+		ok = register < numPortRegisters
+	}
+	return
+}
+
+func (m *Model) next(addr byte) byte {
+	bank := m.Iocon()&IOCON_BIT_BANK != 0
+	if m.Iocon()&IOCON_BIT_SEQOP != 0 {
+		// Byte mode: toggle between the registers of a pair, or stay at the register
+		if bank {
+			return addr
+		}
+		return addr ^ 1
+	}
+	switch {
+	case bank && addr == OLAT_A_BANK:
+		return IODIR_B_BANK
+	case bank && addr >= OLAT_B_BANK, !bank && addr >= OLAT_B_PAIRED:
+		return 0
+	}
+	return addr + 1
+}
+
+func (m *Model) readRegister(addr byte) byte {
+	port, register, ok := m.decode(addr)
+	if !ok {
+		return 0
+	}
+	switch register {
+	case regGpio:
+		regs := &m.Registers[port]
+		return m.Pins(port) ^ (regs[regIpol] & regs[regIodir])
+	case regIntf, regIntcap:
+		return 0
+	}
+	return m.Registers[port][register]
+}
+
+func (m *Model) writeRegister(addr byte, val byte) {
+	port, register, ok := m.decode(addr)
+	if !ok {
+		return
+	}
+	switch register {
+	case regIocon:
+		// The IOCON register is shared by both ports
+		m.Registers[0][regIocon] = val
+		m.Registers[1][regIocon] = val
+	case regGpio:
+		m.Registers[port][regOlat] = val
+	case regIntf, regIntcap:
+		// Read-only
+	default:
+		m.Registers[port][register] = val
+	}
+}
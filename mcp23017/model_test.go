@@ -0,0 +1,43 @@
+package mcp23017
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_model_paired(t *testing.T) {
+	a := assert.New(t)
+	m := NewModel()
+	a.NoError(m.I2cSlaveWrite([]byte{IODIR_PAIRED, OUTPUT, 0x0F}))
+	a.NoError(m.I2cSlaveWrite([]byte{GPPU_B_PAIRED, 0x03}))
+	m.Inputs[1], m.Driven[1] = 0x04, 0x0C
+	a.NoError(m.I2cSlaveWrite([]byte{GPIO_PAIRED, 0xAA, 0xFF}))
+	a.Equal(byte(0xAA), m.Registers[0][regOlat])
+
+	// Output pins reflect OLAT, input pins are driven externally or pulled up
+	data := make([]byte, 4)
+	m.Pointer = GPIO_B_PAIRED
+	a.NoError(m.I2cSlaveRead(data))
+	a.Equal([]byte{0xF7, 0xAA, 0xFF, OUTPUT}, data, "Reads GPIO_B, OLAT_A, OLAT_B, then wraps to IODIR_A")
+
+	// Byte mode toggles between the registers of a pair
+	a.NoError(m.I2cSlaveWrite([]byte{IOCON_PAIRED, IOCON_BIT_SEQOP}))
+	a.NoError(m.I2cSlaveWrite([]byte{OLAT_A_PAIRED, 1, 2, 3}))
+	a.Equal(byte(3), m.Registers[0][regOlat])
+	a.Equal(byte(2), m.Registers[1][regOlat])
+}
+
+func Test_model_bank(t *testing.T) {
+	a := assert.New(t)
+	m := NewModel()
+	a.NoError(m.I2cSlaveWrite([]byte{IOCON_PAIRED, IOCON_BIT_BANK}))
+	a.Equal(IOCON_BIT_BANK, m.Iocon())
+	a.NoError(m.I2cSlaveWrite([]byte{IODIR_B_BANK, OUTPUT}))
+	a.Equal(OUTPUT, m.Registers[1][regIodir])
+	a.NoError(m.I2cSlaveWrite([]byte{OLAT_A_BANK, 0x11, 0x22}))
+	a.Equal(byte(0x11), m.Registers[0][regOlat])
+	a.Equal(byte(0x22), m.Registers[1][regIodir], "Sequential writes continue at port B")
+	a.NoError(m.I2cSlaveWrite([]byte{INTCON_B_BANK + 1, 0}))
+	a.Equal(byte(0), m.Iocon())
+}
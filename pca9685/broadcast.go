@@ -0,0 +1,74 @@
+package pca9685
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+const (
+	GENERAL_CALL_ADDRESS = byte(0x00)
+	SWRST_DATA           = byte(0x06) // Sent to the GENERAL_CALL_ADDRESS to reset all devices
+)
+
+// Resets all PCA9685 devices on the bus to their power-on state, using the general call address.
+// Other devices supporting the general call reset (e.g. the ADS1115) are reset as well.
+func SoftwareReset(bus ft260.I2cBus) error {
+	return bus.I2cWrite(GENERAL_CALL_ADDRESS, SWRST_DATA)
+}
+
+func subAddressBits(num int) (register byte, mode1Bit byte, err error) {
+	switch num {
+	case 1:
+		return SUBADR1, MODE1_SUB1, nil
+	case 2:
+		return SUBADR2, MODE1_SUB2, nil
+	case 3:
+		return SUBADR3, MODE1_SUB3, nil
+	}
+	return 0, 0, fmt.Errorf("Invalid PCA9685 sub-address %v (must be 1..3)", num)
+}
+
+// Programs the sub-address num (1..3) and makes the device respond to it.
+// The Mode1 field is updated, so the setting is kept by Init.
+func (d *Device) SetSubAddress(num int, addr byte) error {
+	register, bit, err := subAddressBits(num)
+	if err != nil {
+		return err
+	}
+	if err := d.Bus.I2cWrite(d.Addr, register, addr<<1); err != nil {
+		return err
+	}
+	d.Mode1 |= bit
+	return d.Bus.I2cWrite(d.Addr, MODE1, d.Mode1)
+}
+
+// Stops responding to the sub-address num (1..3)
+func (d *Device) DisableSubAddress(num int) error {
+	_, bit, err := subAddressBits(num)
+	if err != nil {
+		return err
+	}
+	d.Mode1 &^= bit
+	return d.Bus.I2cWrite(d.Addr, MODE1, d.Mode1)
+}
+
+// Programs the all-call address and makes the device respond to it
+func (d *Device) SetAllCallAddress(addr byte) error {
+	if err := d.Bus.I2cWrite(d.Addr, ALLCALLADR, addr<<1); err != nil {
+		return err
+	}
+	d.Mode1 |= MODE1_ALLCALL
+	return d.Bus.I2cWrite(d.Addr, MODE1, d.Mode1)
+}
+
+func (d *Device) AllOff() error {
+	return d.AllOffContext(context.Background())
+}
+
+// Switches off all outputs with one write to the ALL_LED registers. When used with an all-call or sub-call
+// address, the outputs of multiple devices are switched off at once.
+func (d *Device) AllOffContext(ctx context.Context) error {
+	return ft260.ContextBus(d.Bus).I2cWriteContext(ctx, d.Addr, ALL_OFF_H, FULL_OFF_BIT)
+}
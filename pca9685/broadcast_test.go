@@ -0,0 +1,42 @@
+package pca9685
+
+import (
+	"github.com/antongulenko/tank/ft260"
+)
+
+func (s *testSuite) TestBroadcast() {
+	sim := ft260.NewSimulator()
+	bus := ft260.NewFt260(sim)
+	models := []*Model{NewModel(), NewModel()}
+	devices := make([]*Device, len(models))
+	for i, m := range models {
+		sim.Attach(ADDRESS+byte(i), m)
+		devices[i] = NewDevice(bus, ADDRESS+byte(i))
+		s.NoError(devices[i].Init())
+		s.NoError(devices[i].Set(0, 0.5))
+	}
+	for _, addr := range []byte{GENERAL_CALL_ADDRESS, DEFAULT_ALLCALL_ADDRESS, 0x60} {
+		sim.Attach(addr, &Broadcast{Addr: addr, Models: models})
+	}
+
+	// Only the second device responds to the sub-address
+	s.NoError(devices[1].SetSubAddress(2, 0x60))
+	s.True(models[1].RespondsTo(0x60))
+	s.False(models[0].RespondsTo(0x60))
+	s.NoError(NewDevice(bus, 0x60).FullOn(1))
+	s.Equal(0.0, models[0].Duty(1))
+	s.Equal(1.0, models[1].Duty(1))
+	s.Error(devices[1].SetSubAddress(4, 0x60))
+
+	s.NoError(NewDevice(bus, DEFAULT_ALLCALL_ADDRESS).AllOff())
+	for _, m := range models {
+		s.Equal([]float64{0, 0}, m.Duties()[:2])
+		s.True(m.Running())
+	}
+
+	s.NoError(SoftwareReset(bus))
+	for _, m := range models {
+		s.True(m.Sleeping())
+		s.False(m.RespondsTo(0x60))
+	}
+}
@@ -0,0 +1,139 @@
+package pca9685
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+// Device is a PCA9685 at a fixed address of an I2C bus. The address can also be an all-call or sub-call address,
+// to write to multiple devices at once. Reading is not possible in that case.
+type Device struct {
+	Bus  ft260.I2cBus
+	Addr byte
+
+	// Written by Init. Reading back multiple outputs requires MODE1_AI.
+	Mode1 byte
+	Mode2 byte
+
+	// PWM frequency in Hz set by Init, 0 keeps the current frequency
+	Frequency float64
+}
+
+func NewDevice(bus ft260.I2cBus, addr byte) *Device {
+	return &Device{
+		Bus:   bus,
+		Addr:  addr,
+		Mode1: MODE1_ALLCALL | MODE1_AI,
+		Mode2: MODE2_OUTDRV,
+	}
+}
+
+// Writes the MODE1 and MODE2 registers and sets the PWM frequency
+func (d *Device) Init() error {
+	if err := d.Bus.I2cWrite(d.Addr, MODE1, d.Mode1); err != nil {
+		return err
+	}
+	if err := d.Bus.I2cWrite(d.Addr, MODE2, d.Mode2); err != nil {
+		return err
+	}
+	if d.Frequency > 0 {
+		return SetFrequency(d.Bus, d.Addr, d.Frequency)
+	}
+	return nil
+}
+
+func (d *Device) SetFrequency(frequency float64) error {
+	return SetFrequency(d.Bus, d.Addr, frequency)
+}
+
+func checkOutput(output int) error {
+	if output < 0 || output >= NUM_OUTPUTS {
+		return fmt.Errorf("Invalid PCA9685 output %v (must be 0..%v)", output, NUM_OUTPUTS-1)
+	}
+	return nil
+}
+
+func (d *Device) Set(output int, onTime float64) error {
+	return d.SetContext(context.Background(), output, onTime)
+}
+
+// Sets the duty cycle (0..1) of a single output
+func (d *Device) SetContext(ctx context.Context, output int, onTime float64) error {
+	if onTime < 0 || onTime > 1 {
+		return fmt.Errorf("Invalid PCA9685 duty cycle %v (must be 0..1)", onTime)
+	}
+	onL, onH, offL, offH := Values(onTime)
+	return d.writeOutput(ctx, output, onL, onH, offL, offH)
+}
+
+func (d *Device) FullOn(output int) error {
+	onL, onH, offL, offH := FullOnValues()
+	return d.writeOutput(context.Background(), output, onL, onH, offL, offH)
+}
+
+func (d *Device) FullOff(output int) error {
+	onL, onH, offL, offH := FullOffValues()
+	return d.writeOutput(context.Background(), output, onL, onH, offL, offH)
+}
+
+func (d *Device) writeOutput(ctx context.Context, output int, onL, onH, offL, offH byte) error {
+	if err := checkOutput(output); err != nil {
+		return err
+	}
+	register := LED0 + byte(output)*BYTE_PER_OUTPUT
+	return ft260.ContextBus(d.Bus).I2cWriteContext(ctx, d.Addr, register, onL, onH, offL, offH)
+}
+
+func (d *Device) Read(from, num int) ([]float64, error) {
+	return d.ReadContext(context.Background(), from, num)
+}
+
+// Reads back the duty cycles (0..1) of num outputs, starting at the given output
+func (d *Device) ReadContext(ctx context.Context, from, num int) ([]float64, error) {
+	if err := checkOutput(from); err != nil {
+		return nil, err
+	}
+	if num < 0 || from+num > NUM_OUTPUTS {
+		return nil, fmt.Errorf("Invalid number of PCA9685 outputs %v starting at %v", num, from)
+	}
+	register := LED0 + byte(from)*BYTE_PER_OUTPUT
+	data, err := ft260.ContextBus(d.Bus).I2cGetContext(ctx, d.Addr, register, num*BYTE_PER_OUTPUT)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != num*BYTE_PER_OUTPUT {
+		return nil, fmt.Errorf("PCA9685 read len %v (need %v byte)", len(data), num*BYTE_PER_OUTPUT)
+	}
+	result := make([]float64, num)
+	for i := range result {
+		r := data[i*BYTE_PER_OUTPUT:]
+		_, result[i] = ParseValues(r[0], r[1], r[2], r[3])
+	}
+	return result, nil
+}
+
+// Replaces the CurrentState of the output with the values read back from the device.
+// The output must cover num PWM outputs, starting at the LEDn register firstPwmOutput.
+// If a duty cycle cannot be produced by any value (e.g. a duty below the calibration offset), all outputs are
+// rewritten with the next update.
+func (d *Device) Sync(output *PwmOutput, firstPwmOutput byte, num int) error {
+	output.OptimizeUpdate = false
+	duties, err := d.Read(int(firstPwmOutput-LED0)/BYTE_PER_OUTPUT, num)
+	if err != nil {
+		return err
+	}
+	values := make([]float64, len(duties))
+	roundTrip := true
+	for i, duty := range duties {
+		values[i] = output.Input(i, duty)
+		// Compare the timer counts, so rounding errors of the brightness correction are ignored
+		if duty != 0 && round(output.Output(i, values[i])*TIMER_RESOLUTION) != round(duty*TIMER_RESOLUTION) {
+			roundTrip = false
+		}
+	}
+	output.CurrentState = values
+	output.OptimizeUpdate = roundTrip
+	return nil
+}
@@ -0,0 +1,91 @@
+package pca9685
+
+import (
+	"github.com/antongulenko/tank/ft260"
+)
+
+func (s *testSuite) TestParseValues() {
+	for _, onTime := range []float64{0, 0.25, 0.5, 1} {
+		delay, parsed := ParseValues(Values(onTime))
+		s.Equal(0.0, delay)
+		s.Equal(onTime, parsed)
+	}
+	delay, onTime := ParseValues(ValuesDelayed(0.5, 0.75))
+	s.Equal(0.5, delay)
+	s.Equal(0.75, onTime)
+
+	_, onTime = ParseValues(FullOnValues())
+	s.Equal(1.0, onTime)
+	_, onTime = ParseValues(FullOffValues())
+	s.Equal(0.0, onTime)
+}
+
+func (s *testSuite) TestDevice() {
+	m := NewModel()
+	sim := ft260.NewSimulator()
+	sim.Attach(ADDRESS, m)
+	d := NewDevice(ft260.NewFt260(sim), ADDRESS)
+	s.NoError(d.Init())
+	s.True(m.Running())
+	s.Equal(MODE2_OUTDRV, m.Registers[MODE2])
+
+	s.NoError(d.Set(1, 0.25))
+	s.NoError(d.FullOn(2))
+	s.NoError(d.FullOff(3))
+	s.InDelta(0.25, m.Duty(1), 0.001)
+	s.Equal(1.0, m.Duty(2))
+	s.Error(d.Set(NUM_OUTPUTS, 0))
+	s.Error(d.Set(0, 1.5))
+
+	values, err := d.Read(0, 4)
+	s.NoError(err)
+	s.Equal([]float64{0, 0.25, 1, 0}, values)
+	_, err = d.Read(NUM_OUTPUTS-1, 2)
+	s.Error(err)
+
+	output := PwmOutput{ValuesTo: 0.5, OptimizeUpdate: false}
+	s.NoError(d.Sync(&output, LED0, 2))
+	s.Equal([]float64{0, 0.5}, output.CurrentState)
+	s.True(output.OptimizeUpdate)
+	s.Empty(output.Update(LED0, []float64{0, 0.5}))
+
+	// Full on cannot be produced with ValuesTo 0.5, so the outputs are rewritten
+	s.NoError(d.Sync(&output, LED1, 2))
+	s.Equal([]float64{0.5, 1}, output.CurrentState)
+	s.False(output.OptimizeUpdate)
+	s.NotEmpty(output.Update(LED1, []float64{0.5, 1}))
+
+	// A duty cycle below the offset is not produced by any value
+	s.NoError(d.Set(4, 0.05))
+	output = PwmOutput{Offset: []float64{0.1}}
+	s.NoError(d.Sync(&output, LED4, 1))
+	s.Equal([]float64{0}, output.CurrentState)
+	s.False(output.OptimizeUpdate)
+	s.NotEmpty(output.Update(LED4, []float64{0}))
+
+	// Rounding errors of the gamma correction do not prevent optimized updates
+	output = PwmOutput{Gamma: 2.2}
+	s.NoError(d.Bus.I2cWrite(d.Addr, output.Update(LED5, []float64{0.3, 0.7, 1})...))
+	output = PwmOutput{Gamma: 2.2}
+	s.NoError(d.Sync(&output, LED5, 3))
+	s.True(output.OptimizeUpdate)
+	s.InDeltaSlice([]float64{0.3, 0.7, 1}, output.CurrentState, 0.01)
+}
+
+func (s *testSuite) TestOutputConfig() {
+	s.Equal(MODE2_OUTDRV, OutputConfig{}.Mode2())
+	s.Equal(MODE2_INVRT|MODE2_OCH|MODE2_OUTNE1, OutputConfig{
+		OpenDrain:   true,
+		Invert:      true,
+		ChangeOnAck: true,
+		Disabled:    DisabledHighImpedance,
+	}.Mode2())
+
+	m := NewModel()
+	sim := ft260.NewSimulator()
+	sim.Attach(ADDRESS, m)
+	d := NewDevice(ft260.NewFt260(sim), ADDRESS)
+	d.Mode2 = OutputConfig{OpenDrain: true, Disabled: DisabledOn}.Mode2()
+	s.NoError(d.Init())
+	s.Equal(MODE2_OUTNE0, m.Registers[MODE2])
+}
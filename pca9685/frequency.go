@@ -0,0 +1,75 @@
+package pca9685
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+// Time for the oscillator to stabilize after leaving sleep mode, before the outputs can be restarted
+var OscillatorStartup = 500 * time.Microsecond
+
+// Returns an error if the frequency cannot be generated with the internal oscillator
+func CheckFrequency(frequency float64) error {
+	if frequency < FREQ_MIN || frequency > FREQ_MAX {
+		return fmt.Errorf("PCA9685 PWM frequency %v out of range (%v - %v Hz)", frequency, FREQ_MIN, FREQ_MAX)
+	}
+	return nil
+}
+
+func SetFrequency(bus ft260.I2cBus, addr byte, frequency float64) error {
+	return SetFrequencyContext(context.Background(), bus, addr, frequency)
+}
+
+// Sets the PWM frequency, using the internal oscillator
+func SetFrequencyContext(ctx context.Context, bus ft260.I2cBus, addr byte, frequency float64) error {
+	if err := CheckFrequency(frequency); err != nil {
+		return err
+	}
+	return SetPrescalerContext(ctx, bus, addr, Prescaler(frequency))
+}
+
+func SetPrescaler(bus ft260.I2cBus, addr byte, prescale byte) error {
+	return SetPrescalerContext(context.Background(), bus, addr, prescale)
+}
+
+// Writes the PRE_SCALE register, which is only possible in sleep mode. The device is put to sleep, and afterwards
+// restored to the previous MODE1 value. PWM outputs that were running before are restarted.
+func SetPrescalerContext(ctx context.Context, bus ft260.I2cBus, addr byte, prescale byte) error {
+	if prescale < FREQ_MAX_PRESCALE {
+		return fmt.Errorf("PCA9685 PRE_SCALE value %#02x too small (minimum %#02x)", prescale, FREQ_MAX_PRESCALE)
+	}
+	ctxBus := ft260.ContextBus(bus)
+	mode1, err := ctxBus.I2cGetContext(ctx, addr, MODE1, 1)
+	if err != nil {
+		return err
+	}
+	if len(mode1) != 1 {
+		return fmt.Errorf("PCA9685 MODE1 read len %v (need 1 byte)", len(mode1))
+	}
+	old := mode1[0] &^ MODE1_RESTART // Writing a 1 to RESTART while sleeping has no effect, avoid confusion
+
+	if err := ctxBus.I2cWriteContext(ctx, addr, MODE1, old|MODE1_SLEEP); err != nil {
+		return err
+	}
+	if err := ctxBus.I2cWriteContext(ctx, addr, PRE_SCALE, prescale); err != nil {
+		return err
+	}
+	if err := ctxBus.I2cWriteContext(ctx, addr, MODE1, old); err != nil {
+		return err
+	}
+	if old&MODE1_SLEEP != 0 {
+		// The device was sleeping before, the outputs will be started when it is woken up
+		return nil
+	}
+	select {
+	case <-time.After(OscillatorStartup):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// If the outputs were active before sleeping, RESTART now reads as 1 and writing 1 restarts them.
+	// Otherwise, writing 1 has no effect.
+	return ctxBus.I2cWriteContext(ctx, addr, MODE1, old|MODE1_RESTART)
+}
@@ -0,0 +1,31 @@
+package pca9685
+
+import (
+	"github.com/antongulenko/tank/ft260"
+)
+
+func (s *testSuite) TestSetFrequency() {
+	m := NewModel()
+	sim := ft260.NewSimulator()
+	sim.Attach(ADDRESS, m)
+	bus := ft260.NewFt260(sim)
+
+	// Sleeping devices stay asleep
+	s.NoError(SetFrequency(bus, ADDRESS, 1000))
+	s.InDelta(1000, m.Frequency(), 20)
+	s.True(m.Sleeping())
+
+	// Running outputs are restarted
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_ALLCALL | MODE1_AI}))
+	s.NoError(m.I2cSlaveWrite([]byte{LED3, 0, 0, 0, 8}))
+	s.NoError(SetFrequency(bus, ADDRESS, 50))
+	s.InDelta(50, m.Frequency(), 1)
+	s.True(m.Running())
+	s.Equal(MODE1_ALLCALL|MODE1_AI, m.Registers[MODE1])
+	s.Equal(0.5, m.Duty(3))
+
+	s.Error(SetFrequency(bus, ADDRESS, 2000))
+	s.Error(SetFrequency(bus, ADDRESS, 10))
+	s.Error(SetPrescaler(bus, ADDRESS, 2))
+	s.InDelta(50, m.Frequency(), 1)
+}
@@ -0,0 +1,220 @@
+package pca9685
+
+import "fmt"
+
+// Model is a software model of the PCA9685 registers. It implements the I2cSlave interface of the ft260.Simulator,
+// so it can be attached to the simulated I2C bus at any address.
+// The PWM outputs run while MODE1_SLEEP is cleared. If SLEEP is set while outputs are active, MODE1_RESTART is set
+// and the outputs stay halted after waking up, until a 1 is written to the RESTART bit.
+type Model struct {
+	Registers  [256]byte
+	Pointer    byte
+	Oscillator float64 // Defaults to INTERNAL_OSCILLATOR
+}
+
+func NewModel() *Model {
+	m := new(Model)
+	m.Reset()
+	return m
+}
+
+// Reset restores the power-on register values
+func (m *Model) Reset() {
+	m.Registers = [256]byte{}
+	m.Pointer = 0
+	m.Registers[MODE1] = MODE1_ALLCALL | MODE1_SLEEP
+	m.Registers[MODE2] = MODE2_OUTDRV
+	m.Registers[SUBADR1] = 0xE2
+	m.Registers[SUBADR2] = 0xE4
+	m.Registers[SUBADR3] = 0xE8
+	m.Registers[ALLCALLADR] = 0xE0
+	for i := 0; i < NUM_OUTPUTS; i++ {
+		m.Registers[outputRegister(i)+3] = FULL_OFF_BIT
+	}
+	m.Registers[PRE_SCALE] = DEFAULT_PRESCALE
+}
+
+func (m *Model) I2cSlaveWrite(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	m.Pointer = data[0]
+	for _, b := range data[1:] {
+		m.writeRegister(m.Pointer, b)
+		m.increment()
+	}
+	return nil
+}
+
+func (m *Model) I2cSlaveRead(data []byte) error {
+	for i := range data {
+		data[i] = m.readRegister(m.Pointer)
+		m.increment()
+	}
+	return nil
+}
+
+func (m *Model) increment() {
+	if m.Registers[MODE1]&MODE1_AI != 0 {
+		m.Pointer++
+	}
+}
+
+func (m *Model) readRegister(register byte) byte {
+	if isReserved(register) || (register >= ALL_ON_L && register <= ALL_OFF_H) {
+		return 0 // The ALL_LED registers always read as zero
+	}
+	return m.Registers[register]
+}
+
+func (m *Model) writeRegister(register byte, value byte) {
+	switch {
+	case isReserved(register):
+	case register == MODE1:
+		m.writeMode1(value)
+	case register >= ALL_ON_L && register <= ALL_OFF_H:
+		for i := 0; i < NUM_OUTPUTS; i++ {
+			m.Registers[outputRegister(i)+register-ALL_ON_L] = value
+		}
+	case register == PRE_SCALE:
+		// The prescaler can only be changed while the oscillator is off
+		if m.Sleeping() {
+			m.Registers[PRE_SCALE] = value
+		}
+	default:
+		m.Registers[register] = value
+	}
+}
+
+func (m *Model) writeMode1(value byte) {
+	old := m.Registers[MODE1]
+	restart := old & MODE1_RESTART
+	if value&MODE1_RESTART != 0 && value&MODE1_SLEEP == 0 {
+		restart = 0
+	}
+	if old&MODE1_SLEEP == 0 && value&MODE1_SLEEP != 0 && m.active() {
+		restart = MODE1_RESTART
+	}
+	// EXTCLK can only be set in sleep mode, and only be cleared by a reset
+	extclk := old & MODE1_EXTCLK
+	if old&MODE1_SLEEP != 0 {
+		extclk |= value & MODE1_EXTCLK
+	}
+	m.Registers[MODE1] = value&^(MODE1_RESTART|MODE1_EXTCLK) | restart | extclk
+}
+
+func isReserved(register byte) bool {
+	return register > LED15_OFF_H && register < ALL_ON_L
+}
+
+func outputRegister(output int) byte {
+	return LED0 + byte(output)*BYTE_PER_OUTPUT
+}
+
+func (m *Model) active() bool {
+	for i := 0; i < NUM_OUTPUTS; i++ {
+		if m.configuredDuty(i) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Model) Sleeping() bool {
+	return m.Registers[MODE1]&MODE1_SLEEP != 0
+}
+
+// Returns true if the PWM outputs are running, i.e. not in sleep mode and not waiting for a restart
+func (m *Model) Running() bool {
+	return m.Registers[MODE1]&(MODE1_SLEEP|MODE1_RESTART) == 0
+}
+
+// Returns the on and off counter values of the output, and the FULL_ON and FULL_OFF bits
+func (m *Model) Timer(output int) (on, off int, fullOn, fullOff bool) {
+	r := m.Registers[outputRegister(output):]
+	on = int(r[0]) | int(r[1]&0x0F)<<8
+	off = int(r[2]) | int(r[3]&0x0F)<<8
+	return on, off, r[1]&FULL_ON_BIT != 0, r[3]&FULL_OFF_BIT != 0
+}
+
+// Returns the fraction of the PWM cycle the output is on (0..1), regardless of MODE2_INVRT.
+// Halted outputs are always off.
+func (m *Model) Duty(output int) float64 {
+	if !m.Running() {
+		return 0
+	}
+	return m.configuredDuty(output)
+}
+
+// Returns the duty cycles of all outputs
+func (m *Model) Duties() []float64 {
+	result := make([]float64, NUM_OUTPUTS)
+	for i := range result {
+		result[i] = m.Duty(i)
+	}
+	return result
+}
+
+// Returns the point in the PWM cycle when the output is switched on (0..1)
+func (m *Model) Delay(output int) float64 {
+	on, _, _, _ := m.Timer(output)
+	return float64(on) / TIMER_RESOLUTION
+}
+
+func (m *Model) configuredDuty(output int) float64 {
+	on, off, fullOn, fullOff := m.Timer(output)
+	switch {
+	case fullOff:
+		return 0
+	case fullOn:
+		return 1
+	case on == off:
+		return 0
+	}
+	return float64((off-on+TIMER_RESOLUTION)%TIMER_RESOLUTION) / TIMER_RESOLUTION
+}
+
+// Returns the PWM frequency configured through PRE_SCALE
+func (m *Model) Frequency() float64 {
+	oscillator := m.Oscillator
+	if oscillator == 0 {
+		oscillator = INTERNAL_OSCILLATOR
+	}
+	return oscillator / (TIMER_RESOLUTION * (float64(m.Registers[PRE_SCALE]) + 1))
+}
+
+// Returns true if the model responds to the 7-bit all-call or sub-call address
+func (m *Model) RespondsTo(callAddr byte) bool {
+	mode1 := m.Registers[MODE1]
+	return mode1&MODE1_ALLCALL != 0 && m.Registers[ALLCALLADR]>>1 == callAddr ||
+		mode1&MODE1_SUB1 != 0 && m.Registers[SUBADR1]>>1 == callAddr ||
+		mode1&MODE1_SUB2 != 0 && m.Registers[SUBADR2]>>1 == callAddr ||
+		mode1&MODE1_SUB3 != 0 && m.Registers[SUBADR3]>>1 == callAddr
+}
+
+// Broadcast forwards writes to the models responding to a call address. It implements the I2cSlave interface
+// and must be attached to the ft260.Simulator at that address. At the GENERAL_CALL_ADDRESS, the software reset
+// is supported.
+type Broadcast struct {
+	Addr   byte
+	Models []*Model
+}
+
+func (b *Broadcast) I2cSlaveWrite(data []byte) error {
+	for _, m := range b.Models {
+		if b.Addr == GENERAL_CALL_ADDRESS {
+			if len(data) == 1 && data[0] == SWRST_DATA {
+				m.Reset()
+			}
+		} else if m.RespondsTo(b.Addr) {
+			if err := m.I2cSlaveWrite(data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Broadcast) I2cSlaveRead(data []byte) error {
+	return fmt.Errorf("Cannot read from PCA9685 call address %#02x", b.Addr)
+}
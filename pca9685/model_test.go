@@ -0,0 +1,77 @@
+package pca9685
+
+func (s *testSuite) TestModelAutoIncrement() {
+	m := NewModel()
+	s.False(m.Running())
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_ALLCALL | MODE1_AI}))
+	s.True(m.Running())
+
+	values := make([]byte, 2*BYTE_PER_OUTPUT)
+	ValuesDelayedInto(0.1, 0.2, values)
+	FullOnValuesInto(values[BYTE_PER_OUTPUT:])
+	s.NoError(m.I2cSlaveWrite(append([]byte{LED2}, values...)))
+	s.InDelta(0.2, m.Duty(2), 0.001)
+	s.InDelta(0.1, m.Delay(2), 0.001)
+	s.Equal(1.0, m.Duty(3))
+	s.Equal(0.0, m.Duty(4))
+
+	data := make([]byte, 4)
+	m.Pointer = LED2
+	s.NoError(m.I2cSlaveRead(data))
+	s.Equal(values[:4], data)
+
+	// Without auto-increment, all bytes are written to the same register
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_ALLCALL}))
+	s.NoError(m.I2cSlaveWrite([]byte{LED5_OFF_L, 1, 2, 3}))
+	s.Equal(byte(3), m.Registers[LED5_OFF_L])
+	s.Equal(byte(FULL_OFF_BIT), m.Registers[LED5_OFF_H])
+}
+
+func (s *testSuite) TestModelAllLeds() {
+	m := NewModel()
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_AI}))
+	s.NoError(m.I2cSlaveWrite([]byte{ALL_LEDS, 0, 0, 0, 8}))
+	for i := 0; i < NUM_OUTPUTS; i++ {
+		s.Equal(0.5, m.Duty(i))
+	}
+	data := make([]byte, 4)
+	m.Pointer = ALL_LEDS
+	s.NoError(m.I2cSlaveRead(data))
+	s.Equal([]byte{0, 0, 0, 0}, data)
+}
+
+func (s *testSuite) TestModelSleep() {
+	m := NewModel()
+	s.InDelta(200, m.Frequency(), 5)
+	s.NoError(m.I2cSlaveWrite([]byte{PRE_SCALE, Prescaler(50)}))
+	s.InDelta(50, m.Frequency(), 0.5)
+
+	// The prescaler is ignored while running
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_AI}))
+	s.NoError(m.I2cSlaveWrite([]byte{PRE_SCALE, Prescaler(1000)}))
+	s.InDelta(50, m.Frequency(), 0.5)
+
+	// Sleeping with active outputs requires a restart
+	s.NoError(m.I2cSlaveWrite([]byte{LED0, 0, 0, 0, 4}))
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_AI | MODE1_SLEEP}))
+	s.Equal(0.0, m.Duty(0))
+	s.NotZero(m.Registers[MODE1] & MODE1_RESTART)
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_AI}))
+	s.False(m.Running())
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_AI | MODE1_RESTART}))
+	s.True(m.Running())
+	s.Equal(0.25, m.Duty(0))
+}
+
+func (s *testSuite) TestModelPwmOutput() {
+	m := NewModel()
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_AI}))
+	var out PwmOutput
+	state := []float64{0.5, 1, 0, 0.25}
+	s.NoError(m.I2cSlaveWrite(out.Update(LED4, state)))
+	state[2] = 0.75
+	s.NoError(m.I2cSlaveWrite(out.Update(LED4, state)))
+	for i, val := range state {
+		s.InDelta(val, m.Duty(4+i), 0.001)
+	}
+}
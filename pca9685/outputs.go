@@ -0,0 +1,37 @@
+package pca9685
+
+// State of the outputs while the OE pin is high
+type DisabledOutputs byte
+
+const (
+	DisabledOff           = DisabledOutputs(0)
+	DisabledOn            = DisabledOutputs(MODE2_OUTNE0) // High impedance with open drain outputs
+	DisabledHighImpedance = DisabledOutputs(MODE2_OUTNE1)
+)
+
+// OutputConfig describes the output driver options of the MODE2 register. The zero value is the power-on default.
+type OutputConfig struct {
+	OpenDrain   bool // Otherwise, the outputs are totem pole
+	Invert      bool
+	ChangeOnAck bool // Otherwise, the outputs change on STOP
+	Disabled    DisabledOutputs
+}
+
+func (c OutputConfig) Mode2() byte {
+	mode2 := byte(c.Disabled) & (MODE2_OUTNE0 | MODE2_OUTNE1)
+	if !c.OpenDrain {
+		mode2 |= MODE2_OUTDRV
+	}
+	if c.Invert {
+		mode2 |= MODE2_INVRT
+	}
+	if c.ChangeOnAck {
+		mode2 |= MODE2_OCH
+	}
+	return mode2
+}
+
+// OutputEnable controls the active-low OE pin of one or more PCA9685, e.g. through a GPIO pin
+type OutputEnable interface {
+	SetOutputsEnabled(enabled bool) error
+}
@@ -104,7 +104,7 @@ const (
 	ALL_ON_H
 	ALL_OFF_L
 	ALL_OFF_H
-	PRE_SCALE // Only settable in SLEEP mode. Default value: 0x30
+	PRE_SCALE // Only settable in SLEEP mode. Default value: 0x1E
 	TEST_MODE
 
 	ALL_LEDS = ALL_ON_L
@@ -141,10 +141,13 @@ const (
 	DEFAULT_SUBCALL1_ADDRESS = byte(0x71) // 0111 0001
 	DEFAULT_SUBCALL2_ADDRESS = byte(0x72) // 0111 0010
 	DEFAULT_SUBCALL3_ADDRESS = byte(0x74) // 0111 0100
-	SOFTWARE_RESET_ADDRESS   = byte(0x03) // 0000 0011 // READ to trigger reset
+
+	// Deprecated: the software reset is a write of SWRST_DATA to the GENERAL_CALL_ADDRESS, see SoftwareReset.
+	SOFTWARE_RESET_ADDRESS = byte(0x03)
 )
 
 const (
+	NUM_OUTPUTS      = 16
 	BYTE_PER_OUTPUT  = 4
 	TIMER_MAX        = 4095
 	TIMER_RESOLUTION = TIMER_MAX + 1
@@ -156,7 +159,7 @@ const (
 	FREQ_MAX          = 1525.87890625
 	FREQ_MIN_PRESALE  = byte(0xFF)
 	FREQ_MAX_PRESCALE = byte(0x03) // Minimum value asserted by hardware
-	DEFAULT_PRESCALE  = byte(0x30) // Default PRE_SCALE value, results in 200Hz with the internal oscillator
+	DEFAULT_PRESCALE  = byte(0x1E) // Default PRE_SCALE value, results in 200Hz with the internal oscillator
 
 	INTERNAL_OSCILLATOR = 25000000 // 25 MHz
 )
@@ -190,10 +193,14 @@ func ValuesDelayed(delayTime, onTime float64) (onL, onH, offL, offH byte) {
 	if onTime == 0 {
 		onCount = 0
 	}
+	if onTime == 1 && delayTime > 0 {
+		// The delayed off time would equal the on time, which keeps the output off
+		return FullOnValues()
+	}
 
 	on := delayCount
 	off := on + onCount
-	if off > TIMER_RESOLUTION {
+	if off >= TIMER_RESOLUTION {
 		// Because of the delay, the first on-time is pushed into the second PWM cycle, and must be corrected
 		off -= TIMER_RESOLUTION
 	}
@@ -202,6 +209,28 @@ func ValuesDelayed(delayTime, onTime float64) (onL, onH, offL, offH byte) {
 	return
 }
 
+// Inverse of ValuesDelayed, also handling the FULL_ON_BIT and FULL_OFF_BIT
+func ParseValues(onL, onH, offL, offH byte) (delayTime, onTime float64) {
+	switch {
+	case offH&FULL_OFF_BIT != 0:
+		return 0, 0
+	case onH&FULL_ON_BIT != 0:
+		return 0, 1
+	}
+	on := int(onL) | int(onH&0x0F)<<8
+	off := int(offL) | int(offH&0x0F)<<8
+	if on == 0 {
+		// Revert the -1 correction applied to the off time when there is no delay
+		if off == 0 {
+			return 0, 0
+		}
+		return 0, float64(off+1) / TIMER_RESOLUTION
+	}
+	delayTime = float64(on+1) / TIMER_RESOLUTION
+	onTime = float64((off-on+TIMER_RESOLUTION)%TIMER_RESOLUTION) / TIMER_RESOLUTION
+	return
+}
+
 func round(f float64) int {
 	return int(math.Floor(f + .5))
 }
@@ -254,13 +283,83 @@ func ScaleValue(val float64, from, to float64) float64 {
 	return val
 }
 
+// Inverse of ScaleValue, the result is limited to [0; 1]
+func UnscaleValue(val float64, from, to float64) float64 {
+	if to > from && to > 0 {
+		val = (val - from) / (to - from)
+	}
+	return math.Max(0, math.Min(val, 1))
+}
+
 type PwmOutput struct {
 	// Can be set to >0 to scale values into this range
 	ValuesFrom float64
 	ValuesTo   float64
 
+	// Brightness correction, applied to non-zero values before scaling them. A Gamma >0 maps a value v to v^Gamma.
+	// Afterwards, the calibration Offset[i] + Gain[i]*v is applied to output i. Missing entries default to gain 1
+	// and offset 0.
+	Gamma  float64
+	Gain   []float64
+	Offset []float64
+
 	CurrentState   []float64
 	OptimizeUpdate bool
+
+	// Delays the turn-on of the outputs to different points of the PWM cycle, so they do not all switch on at once.
+	// If Phases is set, output i is delayed by Phases[i % len(Phases)], which must be in [0; 1).
+	// Otherwise, StaggerPhases distributes the delays evenly across all outputs.
+	Phases        []float64
+	StaggerPhases bool
+}
+
+// Returns the duty cycle for the value of the output, after applying the brightness correction and scaling
+func (m *PwmOutput) Output(output int, val float64) float64 {
+	if val > 0 {
+		if m.Gamma > 0 {
+			val = math.Pow(val, m.Gamma)
+		}
+		gain, offset := m.calibration(output)
+		val = math.Max(0, math.Min(offset+gain*val, 1))
+	}
+	return ScaleValue(val, m.ValuesFrom, m.ValuesTo)
+}
+
+// Inverse of Output
+func (m *PwmOutput) Input(output int, duty float64) float64 {
+	val := UnscaleValue(duty, m.ValuesFrom, m.ValuesTo)
+	if val > 0 {
+		gain, offset := m.calibration(output)
+		if gain != 0 {
+			val = math.Max(0, math.Min((val-offset)/gain, 1))
+		}
+		if m.Gamma > 0 {
+			val = math.Pow(val, 1/m.Gamma)
+		}
+	}
+	return val
+}
+
+func (m *PwmOutput) calibration(output int) (gain, offset float64) {
+	gain = 1
+	if output < len(m.Gain) {
+		gain = m.Gain[output]
+	}
+	if output < len(m.Offset) {
+		offset = m.Offset[output]
+	}
+	return
+}
+
+// Returns the turn-on delay of the output, as fraction of the PWM cycle
+func (m *PwmOutput) Phase(output int, numPwmOutputs int) float64 {
+	switch {
+	case len(m.Phases) > 0:
+		return m.Phases[output%len(m.Phases)]
+	case m.StaggerPhases && numPwmOutputs > 0:
+		return float64(output) / float64(numPwmOutputs)
+	}
+	return 0
 }
 
 func (m *PwmOutput) FillCurrentState(newState []float64, from byte) []float64 {
@@ -318,8 +417,8 @@ func (m *PwmOutput) Update(firstPwmOutput byte, newState []float64) []byte {
 	// Compute raw bytes to be sent to the device
 	pwmValues := make([]byte, BYTE_PER_OUTPUT*numChanges)
 	for i, val := range newState[updateFrom:updateTo] {
-		val = ScaleValue(val, m.ValuesFrom, m.ValuesTo)
-		ValuesInto(val, pwmValues[BYTE_PER_OUTPUT*i:])
+		val = m.Output(updateFrom+i, val)
+		ValuesDelayedInto(m.Phase(updateFrom+i, numPwmOutputs), val, pwmValues[BYTE_PER_OUTPUT*i:])
 	}
 
 	return append([]byte{firstPwmOutput + byte(updateFrom)*BYTE_PER_OUTPUT}, pwmValues...)
@@ -0,0 +1,210 @@
+package pca9685
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Servo controls a hobby servo connected to one output of a PCA9685. Positions are given either as angle in degrees,
+// or as pulse width. Both are converted to duty cycles based on the PRE_SCALE register of the device.
+type Servo struct {
+	Device *Device
+	Output int
+
+	// Pulse widths at MinAngle and MaxAngle. Trim is added to all pulses.
+	MinPulse time.Duration
+	MaxPulse time.Duration
+	Trim     time.Duration
+	MinAngle float64
+	MaxAngle float64
+
+	// Angles are limited to MinAngle..MaxAngle, and further to this range. Unused if both are zero.
+	LowerLimit float64
+	UpperLimit float64
+
+	// Maximum angular speed in degrees per second for Move, 0 moves immediately
+	Speed    float64
+	StepTime time.Duration
+
+	// Used to compute the PWM period, see ReadPrescaler
+	Prescale   byte
+	Oscillator float64 // Defaults to INTERNAL_OSCILLATOR
+
+	lock     sync.Mutex
+	angle    float64
+	known    bool // Set when angle contains the current position
+	movement int  // Incremented by every position change, to interrupt running movements
+}
+
+// Returned by Move, if the movement was interrupted by another position change
+var ErrServoMoveInterrupted = errors.New("Servo movement interrupted")
+
+func NewServo(device *Device, output int) *Servo {
+	s := &Servo{
+		Device:   device,
+		Output:   output,
+		MinPulse: 1000 * time.Microsecond,
+		MaxPulse: 2000 * time.Microsecond,
+		MinAngle: 0,
+		MaxAngle: 180,
+		StepTime: 20 * time.Millisecond,
+		Prescale: DEFAULT_PRESCALE,
+	}
+	if device.Frequency > 0 {
+		s.Prescale = Prescaler(device.Frequency)
+	}
+	return s
+}
+
+// Reads the PRE_SCALE register of the device, which must be done after changing the frequency
+func (s *Servo) ReadPrescaler() error {
+	data, err := s.Device.Bus.I2cGet(s.Device.Addr, PRE_SCALE, 1)
+	if err != nil {
+		return err
+	}
+	if len(data) != 1 {
+		return fmt.Errorf("PCA9685 PRE_SCALE read len %v (need 1 byte)", len(data))
+	}
+	s.Prescale = data[0]
+	return nil
+}
+
+// Returns the length of one PWM cycle
+func (s *Servo) Period() time.Duration {
+	oscillator := s.Oscillator
+	if oscillator == 0 {
+		oscillator = INTERNAL_OSCILLATOR
+	}
+	return time.Duration(float64(time.Second) * TIMER_RESOLUTION * (float64(s.Prescale) + 1) / oscillator)
+}
+
+// Returns the pulse width for the angle, after applying the angle limits and the trim
+func (s *Servo) Pulse(angle float64) time.Duration {
+	angle = s.limit(angle)
+	var fraction float64
+	if s.MaxAngle != s.MinAngle {
+		fraction = (angle - s.MinAngle) / (s.MaxAngle - s.MinAngle)
+	}
+	return s.MinPulse + time.Duration(fraction*float64(s.MaxPulse-s.MinPulse)) + s.Trim
+}
+
+func (s *Servo) limit(angle float64) float64 {
+	lower, upper := math.Min(s.MinAngle, s.MaxAngle), math.Max(s.MinAngle, s.MaxAngle)
+	if s.LowerLimit != 0 || s.UpperLimit != 0 {
+		lower, upper = math.Max(lower, s.LowerLimit), math.Min(upper, s.UpperLimit)
+	}
+	return math.Max(lower, math.Min(angle, upper))
+}
+
+func (s *Servo) SetPulse(pulse time.Duration) error {
+	return s.SetPulseContext(context.Background(), pulse)
+}
+
+func (s *Servo) SetPulseContext(ctx context.Context, pulse time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.known = false
+	s.movement++
+	return s.setPulse(ctx, pulse)
+}
+
+func (s *Servo) setPulse(ctx context.Context, pulse time.Duration) error {
+	period := s.Period()
+	if pulse < 0 || pulse > period {
+		return fmt.Errorf("Servo pulse %v out of range (PWM period %v)", pulse, period)
+	}
+	return s.Device.SetContext(ctx, s.Output, float64(pulse)/float64(period))
+}
+
+func (s *Servo) SetAngle(angle float64) error {
+	return s.SetAngleContext(context.Background(), angle)
+}
+
+// Moves the servo to the angle immediately
+func (s *Servo) SetAngleContext(ctx context.Context, angle float64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.movement++
+	return s.setAngle(ctx, angle)
+}
+
+func (s *Servo) setAngle(ctx context.Context, angle float64) error {
+	angle = s.limit(angle)
+	s.known = false
+	if err := s.setPulse(ctx, s.Pulse(angle)); err != nil {
+		return err
+	}
+	s.angle, s.known = angle, true
+	return nil
+}
+
+// Returns the last angle set through this servo. The second return value is false if the position is unknown.
+func (s *Servo) Angle() (float64, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.angle, s.known
+}
+
+// Stops sending pulses, most servos stop holding their position
+func (s *Servo) Release() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.known = false
+	s.movement++
+	return s.Device.FullOff(s.Output)
+}
+
+func (s *Servo) Move(angle float64) error {
+	return s.MoveContext(context.Background(), angle)
+}
+
+// Moves the servo towards the angle, limited by the Speed, and returns when the angle is reached.
+// Without a known current position, the servo is moved immediately. The lock is released between the steps,
+// so other position changes interrupt the movement, which then returns ErrServoMoveInterrupted.
+func (s *Servo) MoveContext(ctx context.Context, angle float64) error {
+	s.lock.Lock()
+	s.movement++
+	movement := s.movement
+	target := s.limit(angle)
+	if s.Speed <= 0 || s.StepTime <= 0 || !s.known {
+		defer s.lock.Unlock()
+		return s.setAngle(ctx, target)
+	}
+	step := s.Speed * s.StepTime.Seconds()
+	s.lock.Unlock()
+
+	ticker := time.NewTicker(s.StepTime)
+	defer ticker.Stop()
+	for {
+		done, err := s.moveStep(ctx, movement, target, step)
+		if done || err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Executes one step of a movement. Returns true when the target is reached.
+func (s *Servo) moveStep(ctx context.Context, movement int, target, step float64) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if movement != s.movement {
+		return false, ErrServoMoveInterrupted
+	}
+	next := target
+	if math.Abs(target-s.angle) > step {
+		next = s.angle + math.Copysign(step, target-s.angle)
+	}
+	if err := s.setAngle(ctx, next); err != nil {
+		return false, err
+	}
+	return next == target, nil
+}
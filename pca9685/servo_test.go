@@ -0,0 +1,73 @@
+package pca9685
+
+import (
+	"time"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+func (s *testSuite) newServo() (*Servo, *Model) {
+	m := NewModel()
+	sim := ft260.NewSimulator()
+	sim.Attach(ADDRESS, m)
+	d := NewDevice(ft260.NewFt260(sim), ADDRESS)
+	d.Frequency = 50
+	s.NoError(d.Init())
+	return NewServo(d, 15), m
+}
+
+func (s *testSuite) TestServoPulse() {
+	servo, m := s.newServo()
+	s.InDelta(float64(20*time.Millisecond), float64(servo.Period()), float64(200*time.Microsecond))
+	s.NoError(servo.ReadPrescaler())
+	s.Equal(Prescaler(50), servo.Prescale)
+
+	s.Equal(1500*time.Microsecond, servo.Pulse(90))
+	s.Equal(1000*time.Microsecond, servo.Pulse(-20))
+	s.Equal(2000*time.Microsecond, servo.Pulse(200))
+	servo.Trim = 20 * time.Microsecond
+	servo.LowerLimit, servo.UpperLimit = 45, 135
+	s.Equal(1270*time.Microsecond, servo.Pulse(0))
+	s.Equal(1770*time.Microsecond, servo.Pulse(180))
+
+	s.NoError(servo.SetAngle(90))
+	s.InDelta(1520.0/servo.Period().Seconds()/1e6, m.Duty(15), 0.001)
+	angle, known := servo.Angle()
+	s.True(known)
+	s.Equal(90.0, angle)
+
+	s.Error(servo.SetPulse(time.Second))
+	s.NoError(servo.Release())
+	_, known = servo.Angle()
+	s.False(known)
+	s.Equal(0.0, m.Duty(15))
+}
+
+func (s *testSuite) TestServoMove() {
+	servo, _ := s.newServo()
+	servo.Speed = 1000
+	servo.StepTime = time.Millisecond
+
+	// Unknown position, moved immediately
+	s.NoError(servo.Move(10))
+	angle, _ := servo.Angle()
+	s.Equal(10.0, angle)
+
+	start := time.Now()
+	s.NoError(servo.Move(20))
+	s.True(time.Since(start) >= 9*time.Millisecond)
+	angle, _ = servo.Angle()
+	s.Equal(20.0, angle)
+
+	// Other position changes are possible during the movement, and interrupt it
+	servo.Speed = 100
+	result := make(chan error)
+	go func() {
+		result <- servo.Move(120)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.NoError(servo.SetAngle(30))
+	s.Equal(ErrServoMoveInterrupted, <-result)
+	angle, _ = servo.Angle()
+	s.Equal(30.0, angle)
+}
@@ -58,3 +58,51 @@ func (s *testSuite) TestPrescale() {
 	s.Equal(FREQ_MAX_PRESCALE, Prescaler(FREQ_MAX), "max freq prescale")
 	s.Equal(byte(0x1e), Prescaler(200), "example prescale")
 }
+
+func (s *testSuite) TestDelayedWrap() {
+	s.Equal([4]byte{0, FULL_ON_BIT, 0, 0}, toArray(ValuesDelayed(0.5, 1)))
+
+	// The off time ends at the last timer count (4095), without wrapping
+	onL, onH, offL, offH := ValuesDelayed(0.75, 0.25)
+	s.Equal([4]byte{0xFF, 0x0B, 0xFF, 0x0F}, [4]byte{onL, onH, offL, offH})
+
+	// The off time is computed as exactly 4096 and wraps to zero, instead of setting the FULL_OFF_BIT
+	delay := 3073.0 / TIMER_RESOLUTION
+	s.Equal([4]byte{0x00, 0x0C, 0x00, 0x00}, toArray(ValuesDelayed(delay, 0.25)))
+	parsedDelay, onTime := ParseValues(ValuesDelayed(delay, 0.25))
+	s.Equal(delay, parsedDelay)
+	s.Equal(0.25, onTime)
+}
+
+func toArray(onL, onH, offL, offH byte) [4]byte {
+	return [4]byte{onL, onH, offL, offH}
+}
+
+func (s *testSuite) TestStaggeredUpdate() {
+	m := NewModel()
+	s.NoError(m.I2cSlaveWrite([]byte{MODE1, MODE1_AI}))
+	output := PwmOutput{StaggerPhases: true}
+	s.NoError(m.I2cSlaveWrite(output.Update(LED0, []float64{0.5, 0.5, 0.5, 1})))
+	for i := 0; i < 3; i++ {
+		s.InDelta(float64(i)/4, m.Delay(i), 0.001)
+	}
+	s.Equal(0.0, m.Delay(3)) // Fully enabled outputs are not delayed
+	s.InDeltaSlice([]float64{0.5, 0.5, 0.5, 1}, m.Duties()[:4], 0.001)
+
+	// The configured pattern is repeated
+	output.Phases = []float64{0, 0.5}
+	output.OptimizeUpdate = false
+	s.NoError(m.I2cSlaveWrite(output.Update(LED0, []float64{0.5, 0.5, 0.5, 0.5})))
+	s.InDeltaSlice([]float64{0, 0.5, 0, 0.5}, []float64{m.Delay(0), m.Delay(1), m.Delay(2), m.Delay(3)}, 0.001)
+}
+
+func (s *testSuite) TestBrightnessCorrection() {
+	output := PwmOutput{ValuesTo: 0.5, Gamma: 2, Gain: []float64{1, 0.5}, Offset: []float64{0, 0.2}}
+	s.InDelta(0.125, output.Output(0, 0.5), 0.0001)
+	s.InDelta(0.5*(0.2+0.5*0.25), output.Output(1, 0.5), 0.0001)
+	s.Equal(0.0, output.Output(1, 0))
+	s.Equal(0.5, output.Output(2, 1))
+	for i := 0; i < 3; i++ {
+		s.InDelta(0.5, output.Input(i, output.Output(i, 0.5)), 0.0001)
+	}
+}
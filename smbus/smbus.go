@@ -0,0 +1,220 @@
+package smbus
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/antongulenko/tank/ft260"
+)
+
+const (
+	BlockMax = 32 // Maximum number of data bytes in block transfers
+)
+
+// Bus implements SMBus transfers on top of an I2C bus.
+// Words are transferred in little-endian order according to the SMBus specification, unless ByteOrder is changed
+// (e.g. to binary.BigEndian for devices like the ADS1115).
+// If PEC is set, a packet error code is appended to all writes, and expected and verified after all reads.
+type Bus struct {
+	ft260.I2cBus
+	ByteOrder binary.ByteOrder
+	PEC       bool
+}
+
+func New(bus ft260.I2cBus) *Bus {
+	return &Bus{
+		I2cBus:    bus,
+		ByteOrder: binary.LittleEndian,
+	}
+}
+
+// Returns a copy of the bus using the given byte order for word transfers
+func (b *Bus) WithByteOrder(order binary.ByteOrder) *Bus {
+	result := *b
+	result.ByteOrder = order
+	return &result
+}
+
+// Returns a copy of the bus with enabled or disabled packet error codes
+func (b *Bus) WithPEC(pec bool) *Bus {
+	result := *b
+	result.PEC = pec
+	return &result
+}
+
+type PecError struct {
+	Addr     byte
+	Command  byte
+	Expected byte
+	Received byte
+}
+
+func (e *PecError) Error() string {
+	return fmt.Sprintf("SMBus: PEC mismatch reading command %02x from %02x (expected %02x, received %02x)", e.Command, e.Addr, e.Expected, e.Received)
+}
+
+func (b *Bus) SendByte(addr byte, val byte) error {
+	return b.write(addr, val, nil)
+}
+
+func (b *Bus) ReceiveByte(addr byte) (byte, error) {
+	data := make([]byte, b.pecLen(1))
+	if err := b.I2cRead(addr, data); err != nil {
+		return 0, err
+	}
+	if b.PEC {
+		if err := b.checkPec(addr, 0, []byte{readAddr(addr)}, data); err != nil {
+			return 0, err
+		}
+	}
+	return data[0], nil
+}
+
+func (b *Bus) WriteByteData(addr byte, command byte, val byte) error {
+	return b.write(addr, command, []byte{val})
+}
+
+func (b *Bus) ReadByteData(addr byte, command byte) (byte, error) {
+	data, err := b.read(addr, command, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+func (b *Bus) WriteWordData(addr byte, command byte, val uint16) error {
+	return b.I2cWrite(addr, b.WordDataBytes(addr, command, val)...)
+}
+
+// Returns the bytes sent by WriteWordData, e.g. to execute the write as part of a larger transaction
+func (b *Bus) WordDataBytes(addr byte, command byte, val uint16) []byte {
+	data := make([]byte, 2)
+	b.byteOrder().PutUint16(data, val)
+	return b.writeBytes(addr, command, data)
+}
+
+func (b *Bus) ReadWordData(addr byte, command byte) (uint16, error) {
+	data, err := b.read(addr, command, 2)
+	if err != nil {
+		return 0, err
+	}
+	return b.byteOrder().Uint16(data), nil
+}
+
+// ProcessCall writes a word to the given command and reads back a word in the same transaction
+func (b *Bus) ProcessCall(addr byte, command byte, val uint16) (uint16, error) {
+	out := make([]byte, 2)
+	b.byteOrder().PutUint16(out, val)
+	in := make([]byte, b.pecLen(2))
+	if err := b.I2cWriteRead(addr, append([]byte{command}, out...), in); err != nil {
+		return 0, err
+	}
+	if b.PEC {
+		prefix := append([]byte{writeAddr(addr), command}, out...)
+		if err := b.checkPec(addr, command, append(prefix, readAddr(addr)), in); err != nil {
+			return 0, err
+		}
+	}
+	return b.byteOrder().Uint16(in), nil
+}
+
+// WriteBlockData writes a byte count followed by up to BlockMax data bytes
+func (b *Bus) WriteBlockData(addr byte, command byte, data []byte) error {
+	if len(data) > BlockMax {
+		return fmt.Errorf("SMBus block write of %v byte exceeds maximum of %v byte", len(data), BlockMax)
+	}
+	return b.write(addr, command, append([]byte{byte(len(data))}, data...))
+}
+
+// ReadBlockData reads a block prefixed by its byte count. Since the I2C bus does not allow to adjust the read length
+// within a transfer, BlockMax bytes are always read from the device and the result is truncated.
+func (b *Bus) ReadBlockData(addr byte, command byte) ([]byte, error) {
+	data := make([]byte, b.pecLen(1+BlockMax))
+	if err := b.I2cWriteRead(addr, []byte{command}, data); err != nil {
+		return nil, err
+	}
+	count := int(data[0])
+	if count > BlockMax {
+		return nil, fmt.Errorf("SMBus block read from %02x returned invalid byte count %v", addr, count)
+	}
+	if b.PEC {
+		if err := b.checkPec(addr, command, []byte{writeAddr(addr), command, readAddr(addr)}, data[:count+2]); err != nil {
+			return nil, err
+		}
+	}
+	return data[1 : 1+count], nil
+}
+
+func (b *Bus) write(addr byte, command byte, data []byte) error {
+	return b.I2cWrite(addr, b.writeBytes(addr, command, data)...)
+}
+
+func (b *Bus) writeBytes(addr byte, command byte, data []byte) []byte {
+	out := append([]byte{command}, data...)
+	if b.PEC {
+		out = append(out, Pec(append([]byte{writeAddr(addr)}, out...)))
+	}
+	return out
+}
+
+func (b *Bus) read(addr byte, command byte, size int) ([]byte, error) {
+	data := make([]byte, b.pecLen(size))
+	if err := b.I2cWriteRead(addr, []byte{command}, data); err != nil {
+		return nil, err
+	}
+	if b.PEC {
+		if err := b.checkPec(addr, command, []byte{writeAddr(addr), command, readAddr(addr)}, data); err != nil {
+			return nil, err
+		}
+	}
+	return data[:size], nil
+}
+
+// Verifies the last byte of data, which is the PEC of all preceding bytes of the transfer
+func (b *Bus) checkPec(addr byte, command byte, prefix []byte, data []byte) error {
+	received := data[len(data)-1]
+	expected := Pec(append(prefix, data[:len(data)-1]...))
+	if received != expected {
+		return &PecError{Addr: addr, Command: command, Expected: expected, Received: received}
+	}
+	return nil
+}
+
+func (b *Bus) pecLen(size int) int {
+	if b.PEC {
+		return size + 1
+	}
+	return size
+}
+
+func (b *Bus) byteOrder() binary.ByteOrder {
+	if b.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return b.ByteOrder
+}
+
+func writeAddr(addr byte) byte {
+	return addr << 1
+}
+
+func readAddr(addr byte) byte {
+	return addr<<1 | 1
+}
+
+// Pec computes the SMBus packet error code (CRC-8 with polynomial x^8 + x^2 + x + 1) over all bytes of a transfer,
+// including the address bytes.
+func Pec(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,96 @@
+package smbus
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/antongulenko/tank/ft260"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPec(t *testing.T) {
+	assert.Equal(t, byte(0xF4), Pec([]byte("123456789")))
+}
+
+func TestTransfers(t *testing.T) {
+	a := assert.New(t)
+	sim := ft260.NewSimulator()
+	slave := new(ft260.RegisterSlave)
+	sim.Attach(0x10, slave)
+	bus := New(ft260.NewFt260(sim))
+
+	a.NoError(bus.WriteByteData(0x10, 0x01, 0xAB))
+	val, err := bus.ReadByteData(0x10, 0x01)
+	a.NoError(err)
+	a.Equal(byte(0xAB), val)
+
+	a.NoError(bus.WriteWordData(0x10, 0x02, 0x1234))
+	a.Equal([]byte{0x34, 0x12}, slave.Registers[0x02:0x04])
+	a.Equal([]byte{0x02, 0x34, 0x12}, bus.WordDataBytes(0x10, 0x02, 0x1234))
+	a.Equal([]byte{0x02, 0x12, 0x34}, bus.WithByteOrder(binary.BigEndian).WordDataBytes(0x10, 0x02, 0x1234))
+	word, err := bus.WithByteOrder(binary.BigEndian).ReadWordData(0x10, 0x02)
+	a.NoError(err)
+	a.Equal(uint16(0x3412), word)
+
+	a.NoError(bus.WriteBlockData(0x10, 0x10, []byte{1, 2, 3}))
+	block, err := bus.ReadBlockData(0x10, 0x10)
+	a.NoError(err)
+	a.Equal([]byte{1, 2, 3}, block)
+	a.Error(bus.WriteBlockData(0x10, 0x10, make([]byte, BlockMax+1)))
+
+	// The register slave returns the registers following the written word
+	slave.Registers[0x22], slave.Registers[0x23] = 0xCD, 0xAB
+	word, err = bus.ProcessCall(0x10, 0x20, 0x5678)
+	a.NoError(err)
+	a.Equal([]byte{0x78, 0x56}, slave.Registers[0x20:0x22])
+	a.Equal(uint16(0xABCD), word)
+}
+
+// Returns the configured response to every read and records all writes
+type pecTestBus struct {
+	written  []byte
+	response []byte
+}
+
+func (b *pecTestBus) I2cWrite(addr byte, data ...byte) error {
+	b.written = data
+	return nil
+}
+
+func (b *pecTestBus) I2cRead(addr byte, data []byte) error {
+	copy(data, b.response)
+	return nil
+}
+
+func (b *pecTestBus) I2cWriteRead(addr byte, out, in []byte) error {
+	b.written = out
+	return b.I2cRead(addr, in)
+}
+
+func (b *pecTestBus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	data := make([]byte, size)
+	return data, b.I2cWriteRead(addr, []byte{registerAddr}, data)
+}
+
+func TestPecTransfers(t *testing.T) {
+	a := assert.New(t)
+	testBus := new(pecTestBus)
+	bus := New(testBus).WithPEC(true)
+
+	a.NoError(bus.WriteByteData(0x10, 0x01, 0xAB))
+	a.Equal([]byte{0x01, 0xAB, Pec([]byte{0x20, 0x01, 0xAB})}, testBus.written)
+
+	testBus.response = []byte{0x34, 0x12, Pec([]byte{0x20, 0x02, 0x21, 0x34, 0x12})}
+	word, err := bus.ReadWordData(0x10, 0x02)
+	a.NoError(err)
+	a.Equal(uint16(0x1234), word)
+
+	testBus.response[2]++
+	_, err = bus.ReadWordData(0x10, 0x02)
+	a.IsType(new(PecError), err)
+
+	testBus.response = []byte{2, 0xA, 0xB, Pec([]byte{0x20, 0x03, 0x21, 2, 0xA, 0xB})}
+	block, err := bus.ReadBlockData(0x10, 0x03)
+	a.NoError(err)
+	a.Equal([]byte{0xA, 0xB}, block)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"math"
@@ -102,6 +103,7 @@ type tankController struct {
 	joystickIndex           int
 	toggleControlModeButton int
 	ledSequenceButton       int
+	emergencyStopButton     int
 	useSingleStick          bool
 	joystickRetryDuration   time.Duration
 
@@ -117,6 +119,7 @@ type tankController struct {
 	sequenceRunning       bool
 	ledControlTime        uint64
 	heartbeatStep         float64
+	i2cStatsInterval      time.Duration
 
 	batteryLeds   tank.LedGroup
 	speedLeds     tank.LedGroup
@@ -133,10 +136,12 @@ func (c *tankController) registerFlags() {
 	flag.IntVar(&c.joystickIndex, "js", c.joystickIndex, "Joystick device index")
 	flag.DurationVar(&c.joystickRetryDuration, "js-retry", c.joystickRetryDuration, "Time to retry joystick initialization")
 	flag.IntVar(&c.ledSequenceButton, "led-sequence-button", c.ledSequenceButton, "Joystick Button index to manually trigger LED sequence")
+	flag.IntVar(&c.emergencyStopButton, "emergency-stop-button", c.emergencyStopButton, "Joystick Button index that triggers and releases the emergency stop (0 disables the button)")
 	flag.IntVar(&c.toggleControlModeButton, "toggleControlModeButton", c.toggleControlModeButton, "Joystick Button index that toggles between one-stick and two-stick control")
 	flag.BoolVar(&c.useSingleStick, "singleStick", c.useSingleStick, "Use single stick for controlling motors")
 	flag.DurationVar(&c.ledControlLoopSleep, "led-control-sleep", c.ledControlLoopSleep, "Sleep time in LED control loop (displaying motor speed and battery voltage)")
 	flag.Float64Var(&c.heartbeatStep, "heartbeat-step", c.heartbeatStep, "Heartbeat progress per LED control loop step")
+	flag.DurationVar(&c.i2cStatsInterval, "i2c-stats-interval", c.i2cStatsInterval, "Interval for logging I2C statistics (requires -i2c-stats)")
 }
 
 func (c *tankController) run() {
@@ -144,6 +149,9 @@ func (c *tankController) run() {
 	golib.Checkerr(c.tank.Setup())
 
 	go c.waitAndInitJoysticks()
+	if c.tank.CollectI2cStats && c.i2cStatsInterval > 0 {
+		go c.logI2cStats()
+	}
 
 	// Run startup sequence
 	if c.startupSequenceRounds > 0 {
@@ -205,6 +213,18 @@ func (c *tankController) setupJoysticks() (*joysticks.HID, error) {
 	} else {
 		return nil, fmt.Errorf("Button for manually triggering LED sequence (index %v) does not exist on joystick", sequenceButton)
 	}
+	if c.emergencyStopButton > 0 {
+		stopButton := uint8(c.emergencyStopButton)
+		if !js.ButtonExists(stopButton) {
+			return nil, fmt.Errorf("Button for emergency stop (index %v) does not exist on joystick", stopButton)
+		}
+		toggleStop := js.OnClose(stopButton)
+		go func() {
+			for range toggleStop {
+				c.toggleEmergencyStop()
+			}
+		}()
+	}
 	c.LedAxis.Notify(js, func(val float32) {
 		if !c.sequenceRunning {
 			log.Println("Led axis value:", val)
@@ -215,9 +235,26 @@ func (c *tankController) setupJoysticks() (*joysticks.HID, error) {
 }
 
 func (c *tankController) stop() {
+	if c.tank.CollectI2cStats {
+		c.printI2cStats()
+	}
 	c.tank.Cleanup()
 }
 
+func (c *tankController) logI2cStats() {
+	for {
+		time.Sleep(c.i2cStatsInterval)
+		c.printI2cStats()
+	}
+}
+
+func (c *tankController) printI2cStats() {
+	snapshot := c.tank.I2cStats().Snapshot()
+	var buf bytes.Buffer
+	golib.Printerr(snapshot.Print(&buf))
+	log.Print(buf.String())
+}
+
 func (c *tankController) toggleMotorController(js *joysticks.HID) {
 	c.useSingleStick = !c.useSingleStick
 	if c.useSingleStick {
@@ -233,6 +270,16 @@ func (c *tankController) toggleMotorController(js *joysticks.HID) {
 	}
 }
 
+func (c *tankController) toggleEmergencyStop() {
+	if c.tank.Motors.Stopped() {
+		log.Println("Releasing emergency stop")
+		golib.Printerr(c.tank.ClearEmergencyStop())
+	} else {
+		log.Warnln("Emergency stop")
+		golib.Printerr(c.tank.EmergencyStop())
+	}
+}
+
 func (c *tankController) runLedSequence(numRounds int) {
 	c.sequenceRunning = true
 	defer func() {
@@ -5,12 +5,14 @@ import (
 	"flag"
 	"fmt"
 	"math"
+	"os"
 	"sort"
 	"strconv"
 	"time"
 
 	"github.com/antongulenko/golib"
 	"github.com/antongulenko/tank/ft260"
+	"github.com/antongulenko/tank/i2cdecode"
 	"github.com/antongulenko/tank/mcp23017"
 	"github.com/antongulenko/tank/pca9685"
 	"github.com/antongulenko/tank/tank"
@@ -20,7 +22,7 @@ import (
 type commandFunc func() error
 
 var (
-	t           = tank.DefaultTank
+	t           = newTank()
 	sleepTime   = 400 * time.Millisecond
 	benchTime   = 3 * time.Second
 	command     = "scan"
@@ -40,9 +42,16 @@ var (
 		"tankLeds":       setTankLeds,
 		"tankLedStartup": playTankLedStartup,
 		"battery":        readBatteryVoltage,
+		"ft260-gpio":     ft260Gpio,
+		"decode":         decodeCaptures,
 	}
 )
 
+func newTank() *tank.Tank {
+	t := tank.DefaultTank
+	return &t
+}
+
 func main() {
 	t.RegisterFlags()
 	flag.UintVar(&ledI2cAddr, "leds", ledI2cAddr, "I2C address of led driver for -c tankLeds")
@@ -59,6 +68,7 @@ func main() {
 }
 
 func doMain() error {
+	t.I2cDecoder().Attach(mcp23017.ADDRESS, i2cdecode.NewMCP23017())
 	if err := t.Setup(); err != nil {
 		return err
 	}
@@ -258,6 +268,37 @@ func playTankLedStartup() error {
 	})
 }
 
+// Arguments: pin name, optionally followed by 0 or 1 to configure the pin as output and set its level
+func ft260Gpio() error {
+	dev := t.Ft260()
+	if dev == nil {
+		return fmt.Errorf("Command ft260-gpio requires an FT260 device")
+	}
+	args := flag.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("Usage: -c ft260-gpio <pin> [0|1]")
+	}
+	pin, err := ft260.ParseGpioPin(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		high, err := strconv.ParseBool(args[1])
+		if err != nil {
+			return err
+		}
+		log.Printf("Setting %v to %v", pin, high)
+		return dev.GpioSetOutput(pin, high)
+	}
+	// Reading does not change the direction, for output pins the current output level is read
+	high, err := dev.GpioRead(pin)
+	if err != nil {
+		return err
+	}
+	log.Printf("%v: %v", pin, high)
+	return nil
+}
+
 func readBatteryVoltage() error {
 	if err := t.Adc.Init(); err != nil {
 		return err
@@ -270,3 +311,26 @@ func readBatteryVoltage() error {
 	log.Printf("Battery percentage: %.2f%% (%.2fV)", percentage*100, volt)
 	return nil
 }
+
+// Arguments: HID capture files, as written with -ft260-capture. Use together with -dummy to decode without an FT260.
+func decodeCaptures() error {
+	if len(flag.Args()) == 0 {
+		return fmt.Errorf("Usage: -c decode <capture file>...")
+	}
+	for _, filename := range flag.Args() {
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		records, err := ft260.ReadCapture(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to read %v: %w", filename, err)
+		}
+		log.Printf("Decoding %v HID reports from %v", len(records), filename)
+		for _, line := range t.I2cDecoder().DecodeCapture(records) {
+			log.Println(line)
+		}
+	}
+	return nil
+}
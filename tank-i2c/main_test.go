@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antongulenko/tank/ads1115"
+	"github.com/antongulenko/tank/ft260"
+	"github.com/antongulenko/tank/mcp23017"
+	"github.com/antongulenko/tank/tank"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupSimulatedTank(a *assert.Assertions) *mcp23017.Model {
+	sim := ft260.NewSimulator()
+	gpio := mcp23017.NewModel()
+	sim.Attach(mcp23017.ADDRESS, gpio)
+	sim.Attach(tank.DefaultTank.Adc.I2cAddr, ads1115.NewModel(func(mux uint16) float64 {
+		return 3
+	}))
+	t = newTank()
+	t.Transport = sim
+	a.NoError(t.Setup())
+	return gpio
+}
+
+func TestGpioBench(test *testing.T) {
+	a := assert.New(test)
+	gpio := setupSimulatedTank(a)
+	defer t.Cleanup()
+	defer func(d time.Duration) { benchTime = d }(benchTime)
+	benchTime = 20 * time.Millisecond
+
+	a.NoError(gpioSpeedTest())
+	a.Equal(byte(gpioConfig), gpio.Iocon())
+	a.Equal(byte(0xFF), gpio.Pins(0))
+}
+
+func TestBattery(test *testing.T) {
+	a := assert.New(test)
+	setupSimulatedTank(a)
+	defer t.Cleanup()
+	a.NoError(readBatteryVoltage())
+	percentage, err := t.Adc.GetBatteryPercentage()
+	a.NoError(err)
+	a.InDelta(0.625, percentage, 0.001)
+	a.NoError(scan())
+}
@@ -1,6 +1,9 @@
 package tank
 
 import (
+	"context"
+	"time"
+
 	"github.com/antongulenko/tank/ads1115"
 	"github.com/antongulenko/tank/ft260"
 	log "github.com/sirupsen/logrus"
@@ -15,6 +18,10 @@ type Adc struct {
 	BatteryMin float64
 	BatteryMax float64
 
+	// Upper bound for reading the battery voltage, so that other I2C operations are not delayed by a slow ADC read.
+	// Disabled by default (0).
+	ReadTimeout time.Duration
+
 	I2cAddr  byte
 	Dummy    bool
 	SkipInit bool
@@ -26,20 +33,32 @@ func (a *Adc) Init() error {
 		return nil
 	} else {
 		log.Printf("Initializing ADC device at %#02x...", a.I2cAddr)
-		err := ads1115.WriteRegister(a.bus, a.I2cAddr, ads1115.REG_CONFIG, adcConfig)
-		if err == nil {
-			// Configure the address of the register to be read by future reads
-			err = a.bus.I2cWrite(a.I2cAddr, ads1115.REG_CONVERSION)
-		}
-		return err
+		return a.configure(a.bus)
 	}
 }
 
+func (a *Adc) configure(bus ft260.I2cBus) error {
+	return RunI2cTransaction(context.Background(), bus,
+		&I2cRequest{Type: I2cWrite, Addr: a.I2cAddr, DataWrite: ads1115.WriteRegisterBytes(a.I2cAddr, ads1115.REG_CONFIG, adcConfig)},
+		// Configure the address of the register to be read by future reads, before other requests can read the ADC
+		&I2cRequest{Type: I2cWrite, Addr: a.I2cAddr, DataWrite: []byte{ads1115.REG_CONVERSION}})
+}
+
 func (a *Adc) GetBatteryVoltage() (float64, error) {
+	ctx := context.Background()
+	if a.ReadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.ReadTimeout)
+		defer cancel()
+	}
+	return a.GetBatteryVoltageContext(ctx)
+}
+
+func (a *Adc) GetBatteryVoltageContext(ctx context.Context) (float64, error) {
 	if a.Dummy {
 		return a.BatteryMax, nil
 	}
-	val, err := ads1115.ReadRegisterDirectly(a.bus, a.I2cAddr)
+	val, err := ads1115.ReadRegisterDirectlyContext(ctx, a.bus, a.I2cAddr)
 	if err != nil {
 		return 0, err
 	}
@@ -1,10 +1,14 @@
 package tank
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/antongulenko/tank/ft260"
-	log "github.com/sirupsen/logrus"
+	"github.com/antongulenko/tank/i2cstats"
 )
 
 const (
@@ -12,8 +16,37 @@ const (
 	I2cRead
 	I2cWriteRead
 	I2cGet
+	I2cTransaction
 )
 
+// Requests of higher priority classes are executed first by the I2C sequencer
+type I2cPriority int
+
+const (
+	I2cPriorityControl  I2cPriority = iota // Default, e.g. motor updates and sensor reads
+	I2cPrioritySafety                      // E.g. stopping the motors
+	I2cPriorityCosmetic                    // E.g. LED animations
+	numI2cPriorities
+)
+
+// Order in which the priority classes are served
+var i2cPriorityOrder = [numI2cPriorities]I2cPriority{I2cPrioritySafety, I2cPriorityControl, I2cPriorityCosmetic}
+
+type i2cPriorityKey struct{}
+
+// WithI2cPriority returns a context, that makes the I2C sequencer execute requests with the given priority
+func WithI2cPriority(ctx context.Context, priority I2cPriority) context.Context {
+	return context.WithValue(ctx, i2cPriorityKey{}, priority)
+}
+
+// Returns the priority stored with WithI2cPriority, or I2cPriorityControl
+func I2cPriorityFromContext(ctx context.Context) I2cPriority {
+	if priority, ok := ctx.Value(i2cPriorityKey{}).(I2cPriority); ok {
+		return priority
+	}
+	return I2cPriorityControl
+}
+
 type I2cRequest struct {
 	Type        int
 	Addr        byte
@@ -23,58 +56,383 @@ type I2cRequest struct {
 	GetSize     int  // Only for I2cGet
 	Error       error
 
-	done bool
-	wait *sync.Cond
+	// Only for I2cTransaction. The steps are executed back to back, without interleaving other requests.
+	// After the first failed step, the remaining steps are skipped with ErrI2cTransactionSkipped.
+	Steps []*I2cRequest
+
+	// Optional. If the context ends before the request is executed, the request is skipped.
+	// Otherwise, the context is passed to the I2C bus.
+	Context context.Context
+
+	// If not set, the priority is taken from Context (see WithI2cPriority)
+	Priority I2cPriority
+
+	// Optional. Called right before the request is executed, e.g. to compute DataWrite from the result of a
+	// previous transaction step. If an error is returned, the request fails with that error.
+	Prepare func(r *I2cRequest) error
+
+	queued   time.Time
+	lock     sync.Mutex
+	started  bool
+	canceled bool
+	done     chan struct{}
 }
 
 func (r *I2cRequest) init() {
-	r.wait = &sync.Cond{L: new(sync.Mutex)}
+	r.done = make(chan struct{})
+	r.queued = time.Now()
+	if r.Priority == I2cPriorityControl && r.Context != nil {
+		r.Priority = I2cPriorityFromContext(r.Context)
+	}
+	if r.Priority < 0 || r.Priority >= numI2cPriorities {
+		r.Priority = I2cPriorityControl
+	}
 }
 
 func (r *I2cRequest) Wait() {
-	r.wait.L.Lock()
-	defer r.wait.L.Unlock()
-	for !r.done {
-		r.wait.Wait()
+	<-r.done
+}
+
+// Done returns a channel that is closed when the request was executed or skipped. The request must be submitted first.
+func (r *I2cRequest) Done() <-chan struct{} {
+	return r.done
+}
+
+// Waits for the request at most for the given duration. Returns false if the request is not done yet,
+// in which case it remains queued.
+func (r *I2cRequest) WaitTimeout(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Waits for the request and returns the read data (for I2cRead, I2cWriteRead and I2cGet) and the error
+func (r *I2cRequest) Result() ([]byte, error) {
+	<-r.done
+	return r.DataRead, r.Error
+}
+
+// OnDone executes the callback in a separate goroutine, after the request is done
+func (r *I2cRequest) OnDone(callback func(req *I2cRequest)) {
+	go func() {
+		<-r.done
+		callback(r)
+	}()
+}
+
+// Waits until the request is done, or the context ends. If the context ends before the request was started,
+// it will not be executed anymore and the context error is returned. Started requests are always awaited,
+// because the I2C operation might still access the request buffers.
+func (r *I2cRequest) WaitContext(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.Error
+	case <-ctx.Done():
+	}
+	r.cancel(ctx.Err())
+	<-r.done
+	return r.Error
+}
+
+// Skips the request with the given error, if it was not started yet
+func (r *I2cRequest) cancel(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.started && !r.canceled {
+		r.canceled = true
+		r.Error = err
+		r.notifyDone()
 	}
 }
 
 func (r *I2cRequest) notifyDone() {
-	r.wait.L.Lock()
-	defer r.wait.L.Unlock()
-	r.done = true
-	r.wait.Broadcast()
+	close(r.done)
+}
+
+// Returns false, if the request should be skipped
+func (r *I2cRequest) start() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.canceled {
+		return false
+	}
+	if r.Context != nil && r.Context.Err() != nil {
+		r.canceled = true
+		r.Error = r.Context.Err()
+		r.notifyDone()
+		return false
+	}
+	r.started = true
+	return true
 }
 
 type sequencedI2cBus struct {
-	usb      *ft260.Ft260
-	i2cQueue chan *I2cRequest
+	bus    ft260.I2cBus
+	queues [numI2cPriorities]chan *I2cRequest
+
+	// Cosmetic requests waiting longer than this are executed before control requests, but never before safety requests
+	starvationLimit time.Duration
+
+	// The next request of every class, only accessed by handleI2cRequests
+	heads [numI2cPriorities]*I2cRequest
+
+	// If set, the number of waiting requests is recorded for every queued request
+	stats *i2cstats.Stats
 }
 
-func (t *sequencedI2cBus) handleI2cRequests() {
-	for req := range t.i2cQueue {
-		switch req.Type {
-		case I2cWrite:
-			req.Error = t.usb.I2cWrite(req.Addr, req.DataWrite...)
-			req.notifyDone()
-		case I2cRead:
-			req.Error = t.usb.I2cRead(req.Addr, req.DataRead)
-			req.notifyDone()
-		case I2cWriteRead:
-			req.Error = t.usb.I2cWriteRead(req.Addr, req.DataWrite, req.DataRead)
-			req.notifyDone()
-		case I2cGet:
-			req.DataRead, req.Error = t.usb.I2cGet(req.Addr, req.GetRegister, req.GetSize)
-			req.notifyDone()
+func (t *sequencedI2cBus) init(queueSize int, starvationLimit time.Duration) {
+	for i := range t.queues {
+		t.queues[i] = make(chan *I2cRequest, queueSize)
+	}
+	t.starvationLimit = starvationLimit
+}
+
+func (r *I2cRequest) execute(bus ft260.I2cBus) {
+	b := ft260.ContextBus(bus)
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if r.Prepare != nil {
+		if r.Error = r.Prepare(r); r.Error != nil {
+			r.notifyDone()
+			return
+		}
+	}
+	switch r.Type {
+	case I2cWrite:
+		r.Error = b.I2cWriteContext(ctx, r.Addr, r.DataWrite...)
+	case I2cRead:
+		r.Error = b.I2cReadContext(ctx, r.Addr, r.DataRead)
+	case I2cWriteRead:
+		r.Error = b.I2cWriteReadContext(ctx, r.Addr, r.DataWrite, r.DataRead)
+	case I2cGet:
+		r.DataRead, r.Error = b.I2cGetContext(ctx, r.Addr, r.GetRegister, r.GetSize)
+	case I2cTransaction:
+		r.Error = executeI2cTransaction(ctx, bus, r.Steps)
+	default:
+		r.Error = fmt.Errorf("Invalid tank I2C request type %v", r.Type)
+	}
+	r.notifyDone()
+}
+
+var ErrI2cTransactionSkipped = errors.New("Skipped after failed I2C transaction step")
+
+// I2cTransactionError reports the first failed step of a transaction
+type I2cTransactionError struct {
+	Step int
+	Err  error
+}
+
+func (e *I2cTransactionError) Error() string {
+	return fmt.Sprintf("I2C transaction step %v failed: %v", e.Step, e.Err)
+}
+
+func (e *I2cTransactionError) Unwrap() error {
+	return e.Err
+}
+
+// I2cTransactionBus is implemented by buses that execute transactions without interleaving other operations
+type I2cTransactionBus interface {
+	I2cTransaction(ctx context.Context, steps ...*I2cRequest) error
+}
+
+// RunI2cTransaction executes the steps as transaction, if the bus supports it.
+// Otherwise, the steps are executed one after another with the same error handling.
+func RunI2cTransaction(ctx context.Context, bus ft260.I2cBus, steps ...*I2cRequest) error {
+	if transactionBus, ok := bus.(I2cTransactionBus); ok {
+		return transactionBus.I2cTransaction(ctx, steps...)
+	}
+	return executeI2cTransaction(ctx, bus, steps)
+}
+
+func executeI2cTransaction(ctx context.Context, bus ft260.I2cBus, steps []*I2cRequest) error {
+	var err error
+	for i, step := range steps {
+		step.init()
+		if err != nil {
+			step.Error = ErrI2cTransactionSkipped
+			step.notifyDone()
+			continue
+		}
+		if step.Context == nil {
+			step.Context = ctx
+		}
+		if step.start() {
+			step.execute(bus)
+		}
+		if step.Error != nil {
+			err = &I2cTransactionError{Step: i, Err: step.Error}
+		}
+	}
+	return err
+}
+
+// I2cBatch is a group of submitted requests, that can be awaited together
+type I2cBatch struct {
+	Requests []*I2cRequest
+	done     chan struct{}
+}
+
+func newI2cBatch(requests []*I2cRequest) *I2cBatch {
+	b := &I2cBatch{
+		Requests: requests,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		for _, req := range requests {
+			req.Wait()
+		}
+		close(b.done)
+	}()
+	return b
+}
+
+// Done returns a channel that is closed when all requests are done
+func (b *I2cBatch) Done() <-chan struct{} {
+	return b.done
+}
+
+// Waits for all requests and returns the first error
+func (b *I2cBatch) Wait() error {
+	<-b.done
+	return b.Err()
+}
+
+// Waits for all requests, or until the context ends. Requests that were not started until then are skipped.
+func (b *I2cBatch) WaitContext(ctx context.Context) error {
+	var result error
+	for _, req := range b.Requests {
+		if err := req.WaitContext(ctx); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// Waits for all requests at most for the given duration. Returns false if some requests are not done yet.
+func (b *I2cBatch) WaitTimeout(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-b.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Returns the first error of all finished requests
+func (b *I2cBatch) Err() error {
+	for _, req := range b.Requests {
+		select {
+		case <-req.done:
+			if req.Error != nil {
+				return req.Error
+			}
 		default:
-			log.Errorln("Ignoring invalid tank I2c request with type", req.Type)
 		}
 	}
+	return nil
+}
+
+func (t *sequencedI2cBus) handleI2cRequests() {
+	for {
+		req := t.nextRequest()
+		if req.start() {
+			req.execute(t.bus)
+		}
+	}
+}
+
+func (t *sequencedI2cBus) nextRequest() *I2cRequest {
+	for {
+		empty := true
+		for i, queue := range t.queues {
+			if t.heads[i] == nil {
+				select {
+				case req := <-queue:
+					t.heads[i] = req
+				default:
+				}
+			}
+			if t.heads[i] != nil {
+				empty = false
+			}
+		}
+		if !empty {
+			break
+		}
+		var req *I2cRequest
+		select {
+		case req = <-t.queues[I2cPrioritySafety]:
+		case req = <-t.queues[I2cPriorityControl]:
+		case req = <-t.queues[I2cPriorityCosmetic]:
+		}
+		t.heads[req.Priority] = req
+	}
+
+	// Safety requests are always served first. Afterwards, a starving cosmetic request is served before control requests.
+	if req := t.heads[I2cPrioritySafety]; req != nil {
+		t.heads[I2cPrioritySafety] = nil
+		return req
+	}
+	if req := t.heads[I2cPriorityCosmetic]; req != nil && t.starvationLimit > 0 && time.Since(req.queued) > t.starvationLimit {
+		t.heads[I2cPriorityCosmetic] = nil
+		return req
+	}
+	for _, priority := range i2cPriorityOrder {
+		if req := t.heads[priority]; req != nil {
+			t.heads[priority] = nil
+			return req
+		}
+	}
+	return nil // Not reached
 }
 
 func (t *sequencedI2cBus) QueueI2cRequest(req *I2cRequest) {
+	t.Submit(req)
+}
+
+// Submit queues the request without waiting for it. The returned request can be used to wait for the result.
+// If the request context ends while the queue is full, the request is done with the context error.
+func (t *sequencedI2cBus) Submit(req *I2cRequest) *I2cRequest {
 	req.init()
-	t.i2cQueue <- req
+	if t.stats != nil {
+		t.stats.RecordQueueDepth(t.QueueDepth())
+	}
+	if req.Context == nil {
+		t.queues[req.Priority] <- req
+		return req
+	}
+	select {
+	case t.queues[req.Priority] <- req:
+	case <-req.Context.Done():
+		req.cancel(req.Context.Err())
+	}
+	return req
+}
+
+// Returns the number of queued requests, that were not yet taken by the sequencer
+func (t *sequencedI2cBus) QueueDepth() int {
+	depth := 0
+	for _, queue := range t.queues {
+		depth += len(queue)
+	}
+	return depth
+}
+
+// Submits all requests and returns a handle for awaiting them together
+func (t *sequencedI2cBus) SubmitBatch(requests ...*I2cRequest) *I2cBatch {
+	for _, req := range requests {
+		t.Submit(req)
+	}
+	return newI2cBatch(requests)
 }
 
 func (t *sequencedI2cBus) I2cRequest(req *I2cRequest) {
@@ -82,46 +440,76 @@ func (t *sequencedI2cBus) I2cRequest(req *I2cRequest) {
 	req.Wait()
 }
 
+// Queues and waits for the request, but returns when the context ends before the request is executed
+func (t *sequencedI2cBus) I2cRequestContext(ctx context.Context, req *I2cRequest) error {
+	req.Context = ctx
+	t.Submit(req)
+	return req.WaitContext(ctx)
+}
+
+// I2cTransaction executes the steps back to back and returns the first error. The results of the individual steps
+// are stored in the step requests. If the context ends before the transaction is started, no step is executed.
+func (t *sequencedI2cBus) I2cTransaction(ctx context.Context, steps ...*I2cRequest) error {
+	req := &I2cRequest{
+		Type:  I2cTransaction,
+		Steps: steps,
+	}
+	return t.I2cRequestContext(ctx, req)
+}
+
 func (t *sequencedI2cBus) I2cWrite(addr byte, data ...byte) error {
+	return t.I2cWriteContext(context.Background(), addr, data...)
+}
+
+func (t *sequencedI2cBus) I2cRead(addr byte, data []byte) error {
+	return t.I2cReadContext(context.Background(), addr, data)
+}
+
+func (t *sequencedI2cBus) I2cWriteRead(addr byte, out, in []byte) error {
+	return t.I2cWriteReadContext(context.Background(), addr, out, in)
+}
+
+func (t *sequencedI2cBus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	return t.I2cGetContext(context.Background(), addr, registerAddr, size)
+}
+
+func (t *sequencedI2cBus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
 	req := &I2cRequest{
 		Addr:      addr,
 		Type:      I2cWrite,
 		DataWrite: data,
 	}
-	t.I2cRequest(req)
-	return req.Error
+	return t.I2cRequestContext(ctx, req)
 }
 
-func (t *sequencedI2cBus) I2cRead(addr byte, data []byte) error {
+func (t *sequencedI2cBus) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
 	req := &I2cRequest{
 		Addr:     addr,
 		Type:     I2cRead,
 		DataRead: data,
 	}
-	t.I2cRequest(req)
-	return req.Error
+	return t.I2cRequestContext(ctx, req)
 }
 
-func (t *sequencedI2cBus) I2cWriteRead(addr byte, out, in []byte) error {
+func (t *sequencedI2cBus) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
 	req := &I2cRequest{
 		Addr:      addr,
 		Type:      I2cWriteRead,
 		DataRead:  in,
 		DataWrite: out,
 	}
-	t.I2cRequest(req)
-	return req.Error
+	return t.I2cRequestContext(ctx, req)
 }
 
-func (t *sequencedI2cBus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+func (t *sequencedI2cBus) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error) {
 	req := &I2cRequest{
 		Addr:        addr,
 		Type:        I2cGet,
 		GetRegister: registerAddr,
 		GetSize:     size,
 	}
-	t.I2cRequest(req)
-	return req.DataRead, req.Error
+	err := t.I2cRequestContext(ctx, req)
+	return req.DataRead, err
 }
 
 type dummyI2cBus struct {
@@ -142,3 +530,19 @@ func (d *dummyI2cBus) I2cWriteRead(addr byte, out, in []byte) error {
 func (d *dummyI2cBus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
 	return nil, nil
 }
+
+func (d *dummyI2cBus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	return ctx.Err()
+}
+
+func (d *dummyI2cBus) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
+	return ctx.Err()
+}
+
+func (d *dummyI2cBus) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
+	return ctx.Err()
+}
+
+func (d *dummyI2cBus) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) ([]byte, error) {
+	return nil, ctx.Err()
+}
@@ -0,0 +1,162 @@
+package tank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/tank/ft260"
+	"github.com/stretchr/testify/assert"
+)
+
+// Records the addresses of all I2C writes, other operations are not supported
+type recordingI2cBus struct {
+	ft260.I2cBus
+	lock  sync.Mutex
+	addrs []byte
+	fail  byte // Writes to this address fail
+}
+
+func (r *recordingI2cBus) I2cWrite(addr byte, data ...byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.addrs = append(r.addrs, addr)
+	if r.fail != 0 && addr == r.fail {
+		return fmt.Errorf("Write to %v failed", addr)
+	}
+	return nil
+}
+
+func (r *recordingI2cBus) written() []byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]byte(nil), r.addrs...)
+}
+
+func queueWrites(seq *sequencedI2cBus, priorities map[byte]I2cPriority, addrs ...byte) []*I2cRequest {
+	var requests []*I2cRequest
+	for _, addr := range addrs {
+		req := &I2cRequest{Type: I2cWrite, Addr: addr, Priority: priorities[addr]}
+		seq.QueueI2cRequest(req)
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+func TestSequencerPriorities(t *testing.T) {
+	a := assert.New(t)
+	bus := new(recordingI2cBus)
+	seq := &sequencedI2cBus{bus: bus}
+	seq.init(10, 0)
+	priorities := map[byte]I2cPriority{
+		1: I2cPriorityCosmetic, 2: I2cPriorityCosmetic, 3: I2cPriorityControl, 4: I2cPrioritySafety, 5: I2cPriorityControl,
+	}
+	requests := queueWrites(seq, priorities, 1, 2, 3, 4, 5)
+	go seq.handleI2cRequests()
+	for _, req := range requests {
+		req.Wait()
+	}
+	a.Equal([]byte{4, 3, 5, 1, 2}, bus.written())
+}
+
+func TestSequencerStarvation(t *testing.T) {
+	a := assert.New(t)
+	bus := new(recordingI2cBus)
+	seq := &sequencedI2cBus{bus: bus}
+	seq.init(10, 10*time.Millisecond)
+	priorities := map[byte]I2cPriority{
+		1: I2cPriorityCosmetic, 2: I2cPriorityControl, 3: I2cPriorityControl,
+	}
+	requests := queueWrites(seq, priorities, 1)
+	time.Sleep(20 * time.Millisecond)
+	requests = append(requests, queueWrites(seq, priorities, 2, 3)...)
+	go seq.handleI2cRequests()
+	for _, req := range requests {
+		req.Wait()
+	}
+	a.Equal([]byte{1, 2, 3}, bus.written())
+}
+
+func TestSequencerStarvationSafety(t *testing.T) {
+	a := assert.New(t)
+	bus := new(recordingI2cBus)
+	seq := &sequencedI2cBus{bus: bus}
+	seq.init(10, 10*time.Millisecond)
+	priorities := map[byte]I2cPriority{
+		1: I2cPriorityCosmetic, 2: I2cPriorityControl, 3: I2cPrioritySafety,
+	}
+
+	// The starving cosmetic request overtakes the control request, but not the safety request
+	requests := queueWrites(seq, priorities, 1)
+	time.Sleep(20 * time.Millisecond)
+	requests = append(requests, queueWrites(seq, priorities, 2, 3)...)
+	go seq.handleI2cRequests()
+	for _, req := range requests {
+		req.Wait()
+	}
+	a.Equal([]byte{3, 1, 2}, bus.written())
+}
+
+func TestSequencerFutures(t *testing.T) {
+	a := assert.New(t)
+	bus := new(recordingI2cBus)
+	seq := &sequencedI2cBus{bus: bus}
+	seq.init(10, 0)
+
+	req := seq.Submit(&I2cRequest{Type: I2cWrite, Addr: 1})
+	callback := make(chan *I2cRequest, 1)
+	req.OnDone(func(req *I2cRequest) {
+		callback <- req
+	})
+	a.False(req.WaitTimeout(10 * time.Millisecond))
+
+	// Canceled requests are done and skipped by the sequencer
+	canceled := seq.Submit(&I2cRequest{Type: I2cWrite, Addr: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Equal(context.Canceled, canceled.WaitContext(ctx))
+	select {
+	case <-canceled.Done():
+	default:
+		a.Fail("Canceled request is not done")
+	}
+
+	batch := seq.SubmitBatch(&I2cRequest{Type: I2cWrite, Addr: 3}, &I2cRequest{Type: 100, Addr: 4})
+	go seq.handleI2cRequests()
+	a.Equal(req, <-callback)
+	a.True(batch.WaitTimeout(time.Second))
+	a.EqualError(batch.Wait(), "Invalid tank I2C request type 100")
+	a.NoError(batch.Requests[0].Error)
+	a.Equal([]byte{1, 3}, bus.written())
+}
+
+func TestSequencerTransaction(t *testing.T) {
+	a := assert.New(t)
+	bus := &recordingI2cBus{fail: 2}
+	seq := &sequencedI2cBus{bus: bus}
+	seq.init(10, 0)
+	steps := []*I2cRequest{
+		{Type: I2cWrite, Addr: 1}, {Type: I2cWrite, Addr: 2}, {Type: I2cWrite, Addr: 3},
+	}
+	tx := seq.Submit(&I2cRequest{Type: I2cTransaction, Steps: steps, Priority: I2cPriorityCosmetic})
+	queueWrites(seq, map[byte]I2cPriority{4: I2cPriorityControl}, 4)
+	go seq.handleI2cRequests()
+
+	_, err := tx.Result()
+	var txErr *I2cTransactionError
+	if a.True(errors.As(err, &txErr)) {
+		a.Equal(1, txErr.Step)
+	}
+	a.NoError(steps[0].Error)
+	a.EqualError(steps[1].Error, "Write to 2 failed")
+	a.Equal(ErrI2cTransactionSkipped, steps[2].Error)
+	a.Equal([]byte{4, 1, 2}, bus.written())
+
+	// Without sequencer, the steps are executed directly
+	bus = &recordingI2cBus{}
+	a.NoError(RunI2cTransaction(context.Background(), bus, &I2cRequest{Type: I2cWrite, Addr: 5}, &I2cRequest{Type: I2cWrite, Addr: 6}))
+	a.Equal([]byte{5, 6}, bus.written())
+}
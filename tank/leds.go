@@ -1,7 +1,10 @@
 package tank
 
 import (
+	"context"
+	"fmt"
 	"math"
+	"sync"
 
 	"github.com/antongulenko/tank/ft260"
 	"github.com/antongulenko/tank/pca9685"
@@ -20,6 +23,15 @@ type MainLeds struct {
 
 	PwmStart  byte // pca9685.LED0
 	PwmOutput pca9685.PwmOutput
+
+	// PWM frequency in Hz, 0 keeps the current frequency (200 Hz after power-on)
+	Frequency float64
+	Outputs   pca9685.OutputConfig
+
+	// lock protects PwmOutput against concurrent updates, calibration and resynchronization. writeLock serializes
+	// the updates, so they reach the device in the order their values were computed. Both are created by Tank.Setup.
+	lock      *sync.Mutex
+	writeLock *sync.Mutex
 }
 
 func (m *MainLeds) Init() error {
@@ -27,30 +39,95 @@ func (m *MainLeds) Init() error {
 		log.Println("Skipping initialization of LEDs")
 	} else {
 		log.Printf("Initializing LED PWM driver at %#02x...", m.I2cAddr)
-		if err := m.bus.I2cWrite(m.I2cAddr, pca9685.MODE1, ledDriverConfig); err != nil {
+		if err := m.configure(m.bus); err != nil {
 			return err
 		}
 	}
 	return m.DisableAll()
 }
 
+// Configures the PWM driver and reads back the current LED state
+func (m *MainLeds) configure(bus ft260.I2cBus) error {
+	device := pca9685.NewDevice(bus, m.I2cAddr)
+	device.Mode1 = ledDriverConfig
+	device.Frequency = m.Frequency
+	device.Mode2 = m.Outputs.Mode2()
+	if err := device.Init(); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return device.Sync(&m.PwmOutput, m.PwmStart, m.NumLeds)
+}
+
 func (m *MainLeds) SetAll(values []float64) error {
-	values = m.PwmOutput.FillCurrentState(values, 0)
-	return m.update(values)
+	return m.SetAllContext(context.Background(), values)
+}
+
+// Like SetAll, but the I2C operation is aborted when the context ends
+func (m *MainLeds) SetAllContext(ctx context.Context, values []float64) error {
+	return m.updateContext(ctx, func() []float64 {
+		return m.PwmOutput.FillCurrentState(values, 0)
+	})
 }
 
-func (m *MainLeds) update(values []float64) error {
-	pwmValues := m.PwmOutput.Update(m.PwmStart, values)
+func (m *MainLeds) update(values func() []float64) error {
+	return m.updateContext(context.Background(), values)
+}
+
+// LED updates are executed with I2cPriorityCosmetic, unless the context contains a different priority.
+// The new values are computed while PwmOutput is locked, so they can be based on its current state.
+func (m *MainLeds) updateContext(ctx context.Context, values func() []float64) error {
+	if ctx.Value(i2cPriorityKey{}) == nil {
+		ctx = WithI2cPriority(ctx, I2cPriorityCosmetic)
+	}
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+	m.lock.Lock()
+	newValues := values()
+	pwmValues := m.PwmOutput.Update(m.PwmStart, newValues)
+	m.lock.Unlock()
 	if m.Dummy {
-		log.Printf("Dummy Leds: update to values: %v", values)
+		log.Printf("Dummy Leds: update to values: %v", newValues)
 		return nil
-	} else {
-		return m.bus.I2cWrite(m.I2cAddr, pwmValues...)
 	}
+	err := ft260.ContextBus(m.bus).I2cWriteContext(ctx, m.I2cAddr, pwmValues...)
+	if err != nil {
+		// The device state is unknown, do not skip any values in the next update
+		m.invalidateState()
+	}
+	return err
+}
+
+// The next update rewrites all outputs
+func (m *MainLeds) invalidateState() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.PwmOutput.OptimizeUpdate = false
 }
 
 func (m *MainLeds) DisableAll() error {
-	return m.update(make([]float64, m.NumLeds))
+	return m.update(func() []float64 {
+		return make([]float64, m.NumLeds)
+	})
+}
+
+// Returns a servo connected to one of the spare outputs of the LED driver, after the outputs used for the LEDs.
+// The servo shares the PWM frequency with the LEDs.
+func (m *MainLeds) Servo(output int) (*pca9685.Servo, error) {
+	firstLed := int(m.PwmStart-pca9685.LED0) / pca9685.BYTE_PER_OUTPUT
+	if output >= firstLed && output < firstLed+m.NumLeds {
+		return nil, fmt.Errorf("Output %v of the LED driver is used for LEDs", output)
+	}
+	if output < 0 || output >= pca9685.NUM_OUTPUTS {
+		return nil, fmt.Errorf("Invalid output %v of the LED driver", output)
+	}
+	if m.Dummy {
+		return nil, fmt.Errorf("Cannot control servos on dummy LED driver")
+	}
+	device := pca9685.NewDevice(m.bus, m.I2cAddr)
+	device.Frequency = m.Frequency
+	return pca9685.NewServo(device, output), nil
 }
 
 func (m *MainLeds) Groups() (red, green, yellow LedGroup) {
@@ -90,8 +167,9 @@ func (m *MainLeds) SetRow(from, to byte, val float64) error {
 			values[i] = 0
 		}
 	}
-	values = m.PwmOutput.FillCurrentState(values, from)
-	return m.update(values)
+	return m.update(func() []float64 {
+		return m.PwmOutput.FillCurrentState(values, from)
+	})
 }
 
 type LedGroup struct {
@@ -103,3 +181,21 @@ type LedGroup struct {
 func (g *LedGroup) Set(val float64) error {
 	return g.Leds.SetRow(g.From, g.To, val)
 }
+
+// Sets the brightness calibration of all LEDs in the group, see pca9685.PwmOutput.Gain and Offset
+func (g *LedGroup) Calibrate(gain, offset float64) {
+	g.Leds.lock.Lock()
+	defer g.Leds.lock.Unlock()
+	output := &g.Leds.PwmOutput
+	for len(output.Gain) <= int(g.To) {
+		output.Gain = append(output.Gain, 1)
+	}
+	for len(output.Offset) <= int(g.To) {
+		output.Offset = append(output.Offset, 0)
+	}
+	for i := g.From; i <= g.To; i++ {
+		output.Gain[i] = gain
+		output.Offset[i] = offset
+	}
+	output.OptimizeUpdate = false
+}
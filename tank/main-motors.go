@@ -1,8 +1,11 @@
 package tank
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
+	"sync"
 
 	"github.com/antongulenko/tank/ft260"
 	"github.com/antongulenko/tank/pca9685"
@@ -22,33 +25,116 @@ type MainMotors struct {
 	// Right Direction, Right Speed, Left Direction, Left Speed
 	PwmStart byte // pca9685.LED0
 
+	// PWM frequency in Hz, 0 keeps the current frequency (200 Hz after power-on)
+	Frequency float64
+	Outputs   pca9685.OutputConfig
+
+	// Distribute the turn-on points of the PWM outputs evenly across the PWM cycle
+	StaggerPwm bool
+
 	pwmOutput pca9685.PwmOutput
+	stopped   bool // Latched by Tank.EmergencyStop, see ClearStop
+
+	// lock protects pwmOutput and stopped. writeLock serializes the motor updates, so an update computed
+	// before an emergency stop cannot be written after it. Both are created by Tank.Setup.
+	lock      *sync.Mutex
+	writeLock *sync.Mutex
 }
 
+var ErrMotorsStopped = errors.New("Motors are stopped by an emergency stop")
+
 func (m *MainMotors) Init() error {
 	if m.Dummy || m.SkipInit {
 		log.Println("Skipping initialization of motors")
 		return nil
 	} else {
 		log.Printf("Initializing motor PWM driver at %#02x...", m.I2cAddr)
-		return m.bus.I2cWrite(m.I2cAddr, pca9685.MODE1, pca9685.MODE1_ALLCALL|pca9685.MODE1_AI)
+		return m.configure(m.bus)
+	}
+}
+
+const numMotorOutputs = 4
+
+// Configures the PWM driver and reads back the current motor state
+func (m *MainMotors) configure(bus ft260.I2cBus) error {
+	device := pca9685.NewDevice(bus, m.I2cAddr)
+	device.Frequency = m.Frequency
+	device.Mode2 = m.Outputs.Mode2()
+	if err := device.Init(); err != nil {
+		return err
 	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return device.Sync(&m.pwmOutput, m.PwmStart, numMotorOutputs)
 }
 
 func (m *MainMotors) ForceSet(left, right float64) error {
-	m.pwmOutput.OptimizeUpdate = false
+	m.invalidateState()
 	return m.Set(left, right)
 }
 
 // Input values in -100..100
 func (m *MainMotors) Set(left, right float64) error {
+	return m.SetContext(context.Background(), left, right)
+}
+
+// Stops both motors. All outputs are written, regardless of the assumed state, and the I2C request
+// is executed with I2cPrioritySafety.
+func (m *MainMotors) Stop() error {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+	m.invalidateState()
+	return m.set(WithI2cPriority(context.Background(), I2cPrioritySafety), 0, 0)
+}
+
+// Like Set, but the I2C operation is aborted when the context ends.
+// After an emergency stop, only zero speeds are accepted until ClearStop is called.
+func (m *MainMotors) SetContext(ctx context.Context, left, right float64) error {
 	if left < -100 || left > 100 {
 		return fmt.Errorf("Illegal left motor %v (must be -100..100)", left)
 	}
 	if right < -100 || right > 100 {
 		return fmt.Errorf("Illegal right motor %v (must be -100..100)", right)
 	}
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+	return m.set(ctx, left, right)
+}
 
+// Latches the stopped state and executes stop while no other motor update can be written
+func (m *MainMotors) emergencyStop(stop func() error) error {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+	m.lock.Lock()
+	m.stopped = true
+	m.pwmOutput.OptimizeUpdate = false
+	m.lock.Unlock()
+	return stop()
+}
+
+// Returns true after an emergency stop, until ClearStop is called
+func (m *MainMotors) Stopped() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stopped
+}
+
+// Allows the motors to move again after an emergency stop
+func (m *MainMotors) ClearStop() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stopped = false
+}
+
+// The next update rewrites all outputs
+func (m *MainMotors) invalidateState() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pwmOutput.OptimizeUpdate = false
+}
+
+// Must be called with writeLock held
+func (m *MainMotors) set(ctx context.Context, left, right float64) error {
 	// Split the two float values into separate speed and direction
 	leftSpeed := math.Abs(left) / 100
 	rightSpeed := math.Abs(right) / 100
@@ -65,7 +151,14 @@ func (m *MainMotors) Set(left, right float64) error {
 	newState := []float64{
 		dirToFloat(leftDir), leftSpeed, dirToFloat(rightDir), rightSpeed,
 	}
+	m.lock.Lock()
+	if m.stopped && (left != 0 || right != 0) {
+		m.lock.Unlock()
+		return ErrMotorsStopped
+	}
+	m.pwmOutput.StaggerPhases = m.StaggerPwm
 	pwmValues := m.pwmOutput.Update(m.PwmStart, newState)
+	m.lock.Unlock()
 
 	dummyText := ""
 	if m.Dummy {
@@ -83,7 +176,11 @@ func (m *MainMotors) Set(left, right float64) error {
 
 	if m.Dummy {
 		return nil
-	} else {
-		return m.bus.I2cWrite(m.I2cAddr, pwmValues...)
 	}
+	err := ft260.ContextBus(m.bus).I2cWriteContext(ctx, m.I2cAddr, pwmValues...)
+	if err != nil {
+		// The device state is unknown, do not skip any values in the next update
+		m.invalidateState()
+	}
+	return err
 }
@@ -0,0 +1,135 @@
+package tank
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/antongulenko/tank/ft260"
+	"github.com/antongulenko/tank/mcp23017"
+	"github.com/antongulenko/tank/pca9685"
+)
+
+// Ft260OutputEnable drives the OE pin of the PWM drivers through a GPIO pin of the FT260
+type Ft260OutputEnable struct {
+	Dev *ft260.Ft260
+	Pin ft260.GpioPin
+}
+
+func (o *Ft260OutputEnable) SetOutputsEnabled(enabled bool) error {
+	return o.Dev.GpioSetOutput(o.Pin, !enabled)
+}
+
+// The GPIO pins of the FT260 are not accessed through the I2C bus, so the context is not used
+func (o *Ft260OutputEnable) SetOutputsEnabledContext(_ context.Context, enabled bool) error {
+	return o.SetOutputsEnabled(enabled)
+}
+
+// Mcp23017OutputEnable drives the OE pin of the PWM drivers through a pin of an MCP23017. Other pins are not modified.
+// The register layout cannot be detected reliably, so Bank must be set if IOCON_BIT_BANK is set on the device.
+type Mcp23017OutputEnable struct {
+	Bus  ft260.I2cBus
+	Addr byte
+	Port int  // 0 for port A, 1 for port B
+	Pin  uint // 0..7
+	Bank bool // Use the BANK register layout, instead of the PAIRED layout (the power-on default)
+}
+
+func (o *Mcp23017OutputEnable) SetOutputsEnabled(enabled bool) error {
+	return o.SetOutputsEnabledContext(context.Background(), enabled)
+}
+
+// Sets the output latch and switches the pin to output in one I2C transaction
+func (o *Mcp23017OutputEnable) SetOutputsEnabledContext(ctx context.Context, enabled bool) error {
+	bit := byte(1) << o.Pin
+	olat, iodir := mcp23017.OLAT_A_PAIRED+byte(o.Port), mcp23017.IODIR_A_PAIRED+byte(o.Port)
+	if o.Bank {
+		olat, iodir = mcp23017.OLAT_A_BANK+byte(o.Port)<<4, mcp23017.IODIR_A_BANK+byte(o.Port)<<4
+	}
+	steps := o.modify(olat, func(val byte) byte {
+		if enabled {
+			return val &^ bit
+		}
+		return val | bit
+	})
+	steps = append(steps, o.modify(iodir, func(val byte) byte {
+		return val &^ bit
+	})...)
+	return RunI2cTransaction(ctx, o.Bus, steps...)
+}
+
+// Returns the transaction steps for reading and modifying one register
+func (o *Mcp23017OutputEnable) modify(register byte, modify func(byte) byte) []*I2cRequest {
+	read := &I2cRequest{Type: I2cGet, Addr: o.Addr, GetRegister: register, GetSize: 1}
+	write := &I2cRequest{Type: I2cWrite, Addr: o.Addr, Prepare: func(r *I2cRequest) error {
+		if len(read.DataRead) != 1 {
+			return fmt.Errorf("MCP23017 read len %v (need 1 byte)", len(read.DataRead))
+		}
+		r.DataWrite = []byte{register, modify(read.DataRead[0])}
+		return nil
+	}}
+	return []*I2cRequest{read, write}
+}
+
+// Implemented by Ft260OutputEnable and Mcp23017OutputEnable
+type outputEnable interface {
+	pca9685.OutputEnable
+	SetOutputsEnabledContext(ctx context.Context, enabled bool) error
+}
+
+// Parses the OutputEnablePin setting: either an FT260 GPIO pin (e.g. GPIO2), or a pin of the MCP23017
+// at mcp23017.ADDRESS (A0..A7, B0..B7). The MCP23017 is accessed through the given bus and must use the
+// PAIRED register layout.
+func (t *Tank) outputEnable(bus ft260.I2cBus) (outputEnable, error) {
+	name := strings.ToUpper(t.OutputEnablePin)
+	if len(name) == 2 && (name[0] == 'A' || name[0] == 'B') && name[1] >= '0' && name[1] <= '7' {
+		return &Mcp23017OutputEnable{
+			Bus:  bus,
+			Addr: mcp23017.ADDRESS,
+			Port: int(name[0] - 'A'),
+			Pin:  uint(name[1] - '0'),
+		}, nil
+	}
+	pin, err := ft260.ParseGpioPin(name)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid output enable pin '%v' (must be FT260 GPIO pin or MCP23017 pin A0..B7)", t.OutputEnablePin)
+	}
+	usb := t.Ft260()
+	if usb == nil {
+		return nil, fmt.Errorf("Output enable pin %v requires an FT260 device", pin)
+	}
+	return &Ft260OutputEnable{Dev: usb, Pin: pin}, nil
+}
+
+// Drives the OE pin of the motor and LED PWM drivers, if OutputEnablePin is configured. Disabling the outputs
+// works independently of the I2C state of the PWM drivers, so it serves as a hardware kill switch.
+func (t *Tank) SetPwmOutputsEnabled(enabled bool) error {
+	t.oeLock.Lock()
+	defer t.oeLock.Unlock()
+	t.pwmOutputsDisabled = !enabled
+	return t.applyOutputEnable(t.Bus())
+}
+
+// Writes the current OE state again, e.g. after the FT260 was reopened
+func (t *Tank) reapplyOutputEnable() error {
+	t.oeLock.Lock()
+	defer t.oeLock.Unlock()
+	return t.applyOutputEnable(t.Bus())
+}
+
+// Must be called with oeLock held, so the OE pin always matches pwmOutputsDisabled
+func (t *Tank) applyOutputEnable(bus ft260.I2cBus) error {
+	if t.OutputEnablePin == "" || t.Dummy {
+		return nil
+	}
+	oe, err := t.outputEnable(bus)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if t.pwmOutputsDisabled {
+		// Disabling the outputs must not wait behind motor or LED updates
+		ctx = WithI2cPriority(ctx, I2cPrioritySafety)
+	}
+	return oe.SetOutputsEnabledContext(ctx, !t.pwmOutputsDisabled)
+}
@@ -0,0 +1,213 @@
+package tank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/antongulenko/tank/ft260"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	errorOther = iota
+	errorBus   // Bus busy, I2C or HID read timeout, can be fixed by resetting the I2C controller
+	errorFatal // HID transport failure, requires reopening the device
+)
+
+func classifyError(err error) int {
+	var transportErr *ft260.TransportError
+	if errors.As(err, &transportErr) {
+		return errorFatal
+	}
+	var shortReadErr *ft260.ShortReadError
+	if errors.As(err, &shortReadErr) {
+		return errorBus
+	}
+	var i2cErr *ft260.I2cError
+	if errors.As(err, &i2cErr) && (i2cErr.TimedOut || i2cErr.BusStatus&ft260.I2C_StatusBusBusy != 0) {
+		return errorBus
+	}
+	return errorOther
+}
+
+// recoveringI2cBus executes I2C operations on the FT260 of a Tank. After USB failures or repeated bus errors,
+// the I2C controller is reset or the device is reopened, and the operation is retried.
+type recoveringI2cBus struct {
+	tank      *Tank
+	lock      sync.Mutex
+	busErrors int // Number of consecutive bus errors
+}
+
+func (r *recoveringI2cBus) I2cWrite(addr byte, data ...byte) error {
+	return r.I2cWriteContext(context.Background(), addr, data...)
+}
+
+func (r *recoveringI2cBus) I2cRead(addr byte, data []byte) error {
+	return r.I2cReadContext(context.Background(), addr, data)
+}
+
+func (r *recoveringI2cBus) I2cWriteRead(addr byte, out, in []byte) error {
+	return r.I2cWriteReadContext(context.Background(), addr, out, in)
+}
+
+func (r *recoveringI2cBus) I2cGet(addr byte, registerAddr byte, size int) ([]byte, error) {
+	return r.I2cGetContext(context.Background(), addr, registerAddr, size)
+}
+
+func (r *recoveringI2cBus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	return r.do(ctx, func(bus *ft260.Ft260) error {
+		return bus.I2cWriteContext(ctx, addr, data...)
+	})
+}
+
+func (r *recoveringI2cBus) I2cReadContext(ctx context.Context, addr byte, data []byte) error {
+	return r.do(ctx, func(bus *ft260.Ft260) error {
+		return bus.I2cReadContext(ctx, addr, data)
+	})
+}
+
+func (r *recoveringI2cBus) I2cWriteReadContext(ctx context.Context, addr byte, out, in []byte) error {
+	return r.do(ctx, func(bus *ft260.Ft260) error {
+		return bus.I2cWriteReadContext(ctx, addr, out, in)
+	})
+}
+
+func (r *recoveringI2cBus) I2cGetContext(ctx context.Context, addr byte, registerAddr byte, size int) (result []byte, err error) {
+	err = r.do(ctx, func(bus *ft260.Ft260) error {
+		var opErr error
+		result, opErr = bus.I2cGetContext(ctx, addr, registerAddr, size)
+		return opErr
+	})
+	return
+}
+
+func (r *recoveringI2cBus) do(ctx context.Context, operation func(bus *ft260.Ft260) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for attempt := 0; ; attempt++ {
+		err := operation(r.tank.Ft260())
+		kind := classifyError(err)
+		if err == nil || kind == errorOther {
+			r.busErrors = 0
+			return err
+		}
+		if attempt >= r.tank.RecoveryRetries || ctx.Err() != nil {
+			return err
+		}
+		if kind == errorBus {
+			r.busErrors++
+			if r.busErrors < r.tank.RecoveryBusErrors {
+				log.Warnf("I2C bus error, retrying: %v", err)
+				continue
+			}
+		}
+		log.Warnf("Recovering from I2C/USB failure: %v", err)
+		if recoverErr := r.recover(); recoverErr == errCannotReopen {
+			return err
+		} else if recoverErr != nil {
+			return fmt.Errorf("%v (recovery failed: %v)", err, recoverErr)
+		}
+		r.busErrors = 0
+	}
+}
+
+var errCannotReopen = errors.New("Cannot reopen injected FT260 transport")
+
+func (r *recoveringI2cBus) recover() error {
+	t := r.tank
+
+	// An aborted transfer might have left the PWM registers half-written, so all outputs are written again
+	defer t.Motors.invalidateState()
+	defer t.Leds.invalidateState()
+
+	err := t.Ft260().Write(&ft260.SetSystemStatus{
+		Request: ft260.SetSystemSetting_I2CReset,
+	})
+	if err == nil {
+		log.Println("Reset FT260 I2C controller")
+		return nil
+	}
+	if t.Transport != nil && t.ReopenTransport == nil {
+		log.Warnf("Failed to reset FT260 I2C controller: %v", err)
+		return errCannotReopen
+	}
+	log.Warnf("Failed to reset FT260 I2C controller, reopening device: %v", err)
+	if err := t.reopenFt260(); err != nil {
+		return err
+	}
+	return t.reinitI2cPeripherals(t.Ft260())
+}
+
+// Replaces the FT260 device. Interrupt events of the new device are forwarded to the channel returned by Interrupts().
+func (t *Tank) reopenFt260() error {
+	old := t.Ft260()
+	old.StopInterrupts()
+	var usb *ft260.Ft260
+	if t.Transport != nil {
+		transport, err := t.ReopenTransport()
+		if err != nil {
+			return err
+		}
+		usb = ft260.NewFt260(transport)
+	} else {
+		if err := old.Close(); err != nil {
+			log.Warnf("Failed to close FT260 device: %v", err)
+		}
+		path := old.Path
+		if path == "" {
+			path = t.UsbDevice
+		}
+		var err error
+		if usb, err = ft260.OpenPath(path); err != nil {
+			return err
+		}
+	}
+	t.captureFt260(usb)
+
+	t.usbLock.Lock()
+	t.usb = usb
+	if t.interrupts != nil {
+		go t.forwardInterrupts(usb.Interrupts())
+	}
+	t.usbLock.Unlock()
+
+	if err := t.validateFt260ChipCode(); err != nil {
+		return err
+	}
+	if err := t.configureFt260(); err != nil {
+		return err
+	}
+	return t.validateFt260()
+}
+
+// Re-initializes the I2C peripherals on the given bus, e.g. after the FT260 was reopened.
+// The state of the PWM outputs is read back from the devices, so the next update rewrites all outputs that differ.
+func (t *Tank) reinitI2cPeripherals(bus ft260.I2cBus) error {
+	if !t.Motors.Dummy && !t.Motors.SkipInit {
+		if err := t.Motors.configure(bus); err != nil {
+			return err
+		}
+	}
+	if !t.Leds.Dummy && !t.Leds.SkipInit {
+		if err := t.Leds.configure(bus); err != nil {
+			return err
+		}
+	}
+	if !t.Adc.Dummy && !t.Adc.SkipInit {
+		if err := t.Adc.configure(bus); err != nil {
+			return err
+		}
+	}
+	if !t.SkipInit && t.OutputEnablePin != "" {
+		// SetPwmOutputsEnabled might be waiting for this recovery while holding oeLock, so the OE pin is
+		// written asynchronously. It is written through the I2C sequencer after the recovery is finished.
+		go func() {
+			if err := t.reapplyOutputEnable(); err != nil {
+				log.Errorf("Failed to restore the PWM output enable pin: %v", err)
+			}
+		}()
+	}
+	return nil
+}
@@ -21,11 +21,15 @@ type SmoothMotor struct {
 }
 
 func (m *SmoothMotor) SetSpeed(val float32) {
+	m.tank.adjustCond.L.Lock()
+	defer m.tank.adjustCond.L.Unlock()
 	m.target = val
-	m.tank.notifyChangedPosition()
+	m.tank.adjustCond.Broadcast()
 }
 
 func (m *SmoothMotor) GetSpeed() float32 {
+	m.tank.adjustCond.L.Lock()
+	defer m.tank.adjustCond.L.Unlock()
 	return m.current
 }
 
@@ -41,6 +45,7 @@ type SmoothTank struct {
 
 	adjustCond *sync.Cond
 	stopFlag   bool
+	loopDone   chan struct{} // Closed when adjustSpeedLoop returns
 }
 
 func (a *SmoothTank) RegisterFlags() {
@@ -61,20 +66,42 @@ func (a *SmoothTank) Setup() error {
 	if err := a.Tank.InitI2cPeripherals(); err != nil {
 		return err
 	}
+	a.loopDone = make(chan struct{})
 	go a.adjustSpeedLoop()
 	return nil
 }
 
 func (a *SmoothTank) Cleanup() {
+	a.adjustCond.L.Lock()
+	a.stopFlag = true
+	a.adjustCond.Broadcast()
+	a.adjustCond.L.Unlock()
+
+	// A motor update of the loop must not overtake stopping the motors
+	if a.loopDone != nil {
+		<-a.loopDone
+	}
+
 	a.adjustCond.L.Lock()
 	defer a.adjustCond.L.Unlock()
 	a.Tank.Cleanup()
+	a.resetSpeeds()
+}
+
+// Like Tank.EmergencyStop, but also discards the current and target speeds of both motors
+func (a *SmoothTank) EmergencyStop() error {
+	a.adjustCond.L.Lock()
+	defer a.adjustCond.L.Unlock()
+	a.resetSpeeds()
+	return a.Tank.EmergencyStop()
+}
+
+// Must be called with adjustCond.L held
+func (a *SmoothTank) resetSpeeds() {
 	a.left.current = 0
 	a.left.target = 0
 	a.right.current = 0
 	a.right.target = 0
-	a.stopFlag = true
-	a.adjustCond.Broadcast()
 }
 
 func (a *SmoothTank) Left() Motor {
@@ -94,21 +121,32 @@ func (a *SmoothTank) adjustSpeedLoop() {
 	if a.DecelSlopeTime > 0 {
 		decelStep = float32(a.SleepTime) / float32(a.DecelSlopeTime)
 	}
-	for !a.stopFlag {
+	defer close(a.loopDone)
+	for {
 		// Wait for incorrect position of any motor
 		a.adjustCond.L.Lock()
 		for a.left.target == a.left.current && a.right.target == a.right.current && !a.stopFlag {
 			a.adjustCond.Wait()
 		}
+		if a.stopFlag {
+			a.adjustCond.L.Unlock()
+			return
+		}
+		if a.Motors.Stopped() {
+			// Discard the targets while the motors are stopped by an emergency stop
+			a.resetSpeeds()
+			a.adjustCond.L.Unlock()
+			continue
+		}
+		a.adjustSpeed(&a.left, accelStep, decelStep)
+		a.adjustSpeed(&a.right, accelStep, decelStep)
+		leftPos := a.calcSpeed(a.left.current)
+		rightPos := a.calcSpeed(a.right.current)
 		a.adjustCond.L.Unlock()
-		if !a.stopFlag {
-			a.adjustSpeed(&a.left, accelStep, decelStep)
-			a.adjustSpeed(&a.right, accelStep, decelStep)
-			leftPos := a.calcSpeed(a.left.current)
-			rightPos := a.calcSpeed(a.right.current)
-			golib.Printerr(a.Motors.Set(leftPos, rightPos))
-			time.Sleep(a.SleepTime)
+		if err := a.Motors.Set(leftPos, rightPos); err != ErrMotorsStopped {
+			golib.Printerr(err)
 		}
+		time.Sleep(a.SleepTime)
 	}
 }
 
@@ -146,9 +184,3 @@ func (a *SmoothTank) calcSpeed(val float32) float64 {
 	}
 	return float64(val * 100)
 }
-
-func (a *SmoothTank) notifyChangedPosition() {
-	a.adjustCond.L.Lock()
-	defer a.adjustCond.L.Unlock()
-	a.adjustCond.Broadcast()
-}
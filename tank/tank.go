@@ -1,26 +1,44 @@
 package tank
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/antongulenko/hid"
 	"github.com/antongulenko/tank/ads1115"
 	"github.com/antongulenko/tank/ft260"
+	"github.com/antongulenko/tank/i2cbroker"
+	"github.com/antongulenko/tank/i2cdecode"
+	"github.com/antongulenko/tank/i2cdev"
+	"github.com/antongulenko/tank/i2cstats"
 	"github.com/antongulenko/tank/pca9685"
 	log "github.com/sirupsen/logrus"
 )
 
 var DefaultTank = Tank{
-	UsbDevice:       "",
-	I2cFreq:         uint(400),
-	I2cRequestQueue: 20,
+	UsbDevice:         "",
+	I2cFreq:           uint(400),
+	I2cRequestQueue:   20,
+	I2cStarvation:     200 * time.Millisecond,
+	RecoveryRetries:   3,
+	RecoveryBusErrors: 2,
+	Interrupt: ft260.InterruptConfig{
+		Trigger: ft260.InterruptTriggerFallingEdge, // ALERT/RDY of ADS1115 and INT of MCP23017 are active-low by default
+	},
+	PwmAllCallAddr: pca9685.DEFAULT_ALLCALL_ADDRESS,
 	Motors: MainMotors{
 		I2cAddr:        pca9685.ADDRESS,
 		PwmStart:       pca9685.LED0,
 		InvertLeftDir:  false,
 		InvertRightDir: false,
+		Frequency:      1000, // The motor drivers whine audibly at the default 200 Hz
 	},
 	Leds: MainLeds{
 		I2cAddr:  pca9685.ADDRESS + 4, // A2 pin set
@@ -29,6 +47,7 @@ var DefaultTank = Tank{
 		PwmOutput: pca9685.PwmOutput{
 			ValuesFrom: 0,
 			ValuesTo:   0.7, // Max brightness, higher values have no visible change
+			Gamma:      2.2, // Perceived brightness grows linearly with the values
 		},
 	},
 	Adc: Adc{
@@ -40,44 +59,134 @@ var DefaultTank = Tank{
 
 type Tank struct {
 	UsbDevice       string
+	I2cDevice       string // If set, use this Linux i2c-dev device (e.g. /dev/i2c-1) instead of the FT260
 	I2cFreq         uint
 	I2cRequestQueue int
+	I2cStarvation   time.Duration // Maximum waiting time of cosmetic I2C requests, before they are preferred over control requests
 	NoI2cSequencer  bool
 	Dummy           bool
 	SkipInit        bool
 
+	// If set, this transport is used instead of opening the FT260 USB device (e.g. ft260.Simulator).
+	// After fatal transport errors, an injected transport is only replaced through ReopenTransport. Without it,
+	// recovery is limited to resetting the I2C controller, and the transport error is returned.
+	Transport       ft260.Transport
+	ReopenTransport func() (ft260.Transport, error)
+
+	// If set, all HID reports exchanged with the FT260 are recorded to this file (see ft260.CaptureTransport)
+	CaptureFile string
+	// If set, HID reports are replayed from this capture file instead of opening the FT260 USB device
+	ReplayFile string
+
+	// If set, all I2C traffic is logged, decoded with the register maps of the I2C peripherals
+	DecodeI2c bool
+
+	// If set, the I2C bus is shared with other processes through this Unix domain socket (see i2cbroker.Server).
+	// Requests of other processes are executed through the I2C sequencer.
+	ServeI2c string
+	// If set, the I2C bus shared by another process is used instead of opening a device.
+	// Cleanup does not disable the motors and LEDs in this case, because they are owned by the other process.
+	ConnectI2c string
+
+	// If set, the I2C bus is replaced by the result of this function, e.g. to inject faults with i2cfault.NewBus.
+	// The wrapped bus sees the operations after automatic recovery, so injected faults are not recovered.
+	WrapBus func(bus ft260.I2cBus) ft260.I2cBus
+
+	// If set, I2C operations and the I2C request queue depth are recorded in I2cStats()
+	CollectI2cStats bool
+
+	// After USB failures or RecoveryBusErrors consecutive bus busy/timeout errors, the FT260 I2C controller is reset,
+	// or the device is reopened and reinitialized. Failed operations are retried up to RecoveryRetries times.
+	NoRecovery        bool
+	RecoveryRetries   int
+	RecoveryBusErrors int
+
+	// If enabled, GPIO3 of the FT260 acts as interrupt input. Events are delivered through Interrupts()
+	EnableInterrupt bool
+	Interrupt       ft260.InterruptConfig
+
 	Motors MainMotors
 	Leds   MainLeds
 	Adc    Adc
 
-	usb       *ft260.Ft260
-	sequencer sequencedI2cBus
+	// All-call address of the PWM drivers of the motors and LEDs, used by EmergencyStop. 0 disables the all-call.
+	PwmAllCallAddr byte
+
+	// GPIO pin connected to the OE pin of the PWM drivers of the motors and LEDs, see SetPwmOutputsEnabled
+	OutputEnablePin string
+
+	usbLock    *sync.RWMutex // Protects usb, which is replaced when reopening the device
+	usb        *ft260.Ft260
+	interrupts chan ft260.InterruptEvent
+	i2cDev     *i2cdev.Bus
+	client     *i2cbroker.Client
+	server     *i2cbroker.Server
+	bus        ft260.I2cBus // Either usb, i2cDev or client
+	capture    *os.File
+	decoder    *i2cdecode.Decoder
+	stats      *i2cstats.Stats
+	sequencer  sequencedI2cBus
+
+	oeLock             *sync.Mutex // Protects pwmOutputsDisabled and the OE pin. Created by Setup.
+	pwmOutputsDisabled bool
 }
 
 func (t *Tank) RegisterFlags() {
 	flag.StringVar(&t.UsbDevice, "dev", t.UsbDevice, "Specify a USB path for FT260")
+	flag.StringVar(&t.I2cDevice, "i2c-dev", t.I2cDevice, "Use the given Linux i2c-dev device (e.g. /dev/i2c-1) instead of the FT260")
 	flag.UintVar(&t.I2cFreq, "freq", t.I2cFreq, "The I2C bus frequency (60 - 3400)")
 	flag.BoolVar(&t.NoI2cSequencer, "no-i2c-sequencer", t.NoI2cSequencer, "Disable the extra goroutine for sequencing I2C commands")
+	flag.DurationVar(&t.I2cStarvation, "i2c-starvation", t.I2cStarvation, "Maximum time cosmetic I2C requests (e.g. LEDs) wait behind motor control requests (0 for no limit). Safety requests are always executed first")
 	flag.BoolVar(&t.Dummy, "dummy", t.Dummy, "Disable USB/I2C peripherals")
 	flag.BoolVar(&t.SkipInit, "skip-init", t.SkipInit, "Do not initialize USB/I2C peripherals, but use for subsequent commands")
+	flag.StringVar(&t.ServeI2c, "i2c-serve", t.ServeI2c, "Share the I2C bus with other processes through the given Unix socket")
+	flag.StringVar(&t.ConnectI2c, "i2c-connect", t.ConnectI2c, "Use the I2C bus shared by another process through the given Unix socket")
+	flag.StringVar(&t.CaptureFile, "ft260-capture", t.CaptureFile, "Record all HID reports exchanged with the FT260 to the given file")
+	flag.StringVar(&t.ReplayFile, "ft260-replay", t.ReplayFile, "Replay HID reports from the given capture file instead of using the FT260")
+	flag.BoolVar(&t.DecodeI2c, "decode-i2c", t.DecodeI2c, "Log all I2C traffic, decoded with the register maps of the I2C peripherals")
+	flag.BoolVar(&t.CollectI2cStats, "i2c-stats", t.CollectI2cStats, "Collect statistics about I2C operations and the I2C request queue")
+	flag.BoolVar(&t.NoRecovery, "no-recovery", t.NoRecovery, "Disable automatic recovery from FT260 USB and I2C bus failures")
+	flag.IntVar(&t.RecoveryRetries, "recovery-retries", t.RecoveryRetries, "Number of retries for I2C operations failing due to USB or bus failures")
+	flag.IntVar(&t.RecoveryBusErrors, "recovery-bus-errors", t.RecoveryBusErrors, "Number of consecutive bus busy/timeout errors before resetting the I2C bus")
+	flag.BoolVar(&t.EnableInterrupt, "interrupt", t.EnableInterrupt, "Enable the FT260 interrupt input (GPIO3)")
+	flag.Var(byteFlag{&t.Interrupt.Trigger}, "interrupt-trigger", "FT260 interrupt trigger (0: rising edge, 1: level high, 2: falling edge, 3: level low)")
+	flag.Var(byteFlag{&t.Interrupt.LevelDuration}, "interrupt-duration", "FT260 interrupt level duration for level triggers (1: 1ms, 2: 5ms, 3: 30ms)")
+
+	flag.StringVar(&t.OutputEnablePin, "pwm-oe-pin", t.OutputEnablePin, "FT260 GPIO pin (e.g. GPIO2) or MCP23017 pin (A0..B7, PAIRED register layout) driving the OE pin of the motor and LED PWM drivers")
+	flag.Var(byteFlag{&t.PwmAllCallAddr}, "pwm-allcall", "All-call I2C address of the motor and LED PWM drivers, used to stop both at once (0 to disable)")
 
 	// Motors
 	flag.BoolVar(&t.Motors.Dummy, "dummy-motors", t.Motors.Dummy, "Disable real motor control, only output commands")
 	flag.BoolVar(&t.Motors.SkipInit, "skip-init-motors", t.Motors.SkipInit, "Do not initialize motor I2C device, but use for subsequent commands")
+	flag.Float64Var(&t.Motors.Frequency, "motor-pwm-freq", t.Motors.Frequency, "PWM frequency of the motor driver in Hz (24 - 1525, 0 to keep the current frequency)")
+	flag.BoolVar(&t.Motors.StaggerPwm, "motor-pwm-stagger", t.Motors.StaggerPwm, "Distribute the turn-on points of the motor PWM outputs across the PWM cycle")
+	registerOutputFlags(&t.Motors.Outputs, "motor", "motor")
 
 	// LEDs
 	flag.BoolVar(&t.Leds.Dummy, "dummy-leds", t.Leds.Dummy, "Disable real LED control, only output values")
 	flag.BoolVar(&t.Leds.SkipInit, "skip-init-leds", t.Leds.SkipInit, "Do not initialize LED I2C device, but use for subsequent commands")
 	flag.IntVar(&t.Leds.NumLeds, "num-leds", t.Leds.NumLeds, "Number of main leds")
+	flag.Float64Var(&t.Leds.Frequency, "led-pwm-freq", t.Leds.Frequency, "PWM frequency of the LED driver in Hz (24 - 1525, 0 to keep the current frequency)")
+	flag.Float64Var(&t.Leds.PwmOutput.Gamma, "led-gamma", t.Leds.PwmOutput.Gamma, "Gamma correction of the LED brightness (0 for linear PWM values)")
+	flag.Var(floatsFlag{&t.Leds.PwmOutput.Gain}, "led-gain", "Comma-separated brightness gain of each LED, applied after the gamma correction")
+	flag.Var(floatsFlag{&t.Leds.PwmOutput.Offset}, "led-offset", "Comma-separated brightness offset of each LED, applied after the gamma correction")
+	flag.BoolVar(&t.Leds.PwmOutput.StaggerPhases, "led-pwm-stagger", t.Leds.PwmOutput.StaggerPhases, "Distribute the turn-on points of the LED PWM outputs across the PWM cycle, to reduce supply ripple")
+	registerOutputFlags(&t.Leds.Outputs, "led", "LED")
 
 	// ADC, Battery
 	flag.BoolVar(&t.Adc.Dummy, "dummy-adc", t.Adc.Dummy, "Disable real ADC control, only output values")
 	flag.BoolVar(&t.Adc.SkipInit, "skip-init-adc", t.Adc.SkipInit, "Do not initialize ADC I2C device, but use for subsequent commands")
 	flag.Float64Var(&t.Adc.BatteryMin, "battery-min", t.Adc.BatteryMin, "Minimum value for battery voltage")
 	flag.Float64Var(&t.Adc.BatteryMax, "battery-max", t.Adc.BatteryMax, "Minimum value for battery voltage")
+	flag.DurationVar(&t.Adc.ReadTimeout, "adc-timeout", t.Adc.ReadTimeout, "Timeout for reading the battery voltage from the ADC, e.g. 100ms (0 to disable)")
 }
 
 func (t *Tank) Setup() error {
+	t.Leds.lock = new(sync.Mutex)
+	t.Leds.writeLock = new(sync.Mutex)
+	t.oeLock = new(sync.Mutex)
+	t.Motors.lock = new(sync.Mutex)
+	t.Motors.writeLock = new(sync.Mutex)
 	if t.Dummy {
 		log.Println("Dummy tank: not using USB/I2C peripherals")
 		t.Leds.Dummy = true
@@ -91,34 +200,106 @@ func (t *Tank) Setup() error {
 			t.Adc.SkipInit = true
 		}
 
-		t.sequencer.i2cQueue = make(chan *I2cRequest, t.I2cRequestQueue)
+		t.sequencer.init(t.I2cRequestQueue, t.I2cStarvation)
 		if !t.NoI2cSequencer {
 			go t.sequencer.handleI2cRequests()
 		}
 
-		// Prepare Usb HID library, open FT260 device
-		if err := hid.Init(); err != nil {
-			return err
+		if t.I2cDevice != "" {
+			i2cDev, err := i2cdev.Open(t.I2cDevice)
+			if err != nil {
+				return err
+			}
+			log.Printf("Using I2C device %v", t.I2cDevice)
+			log.Printf("I2C bus frequency %v kHz does not apply to %v, the bus frequency is configured by the kernel driver", t.I2cFreq, t.I2cDevice)
+			t.i2cDev = i2cDev
+			t.bus = i2cDev
+		} else if t.ConnectI2c != "" {
+			client, err := i2cbroker.Dial(t.ConnectI2c)
+			if err != nil {
+				return err
+			}
+			log.Printf("Using I2C bus shared through %v", t.ConnectI2c)
+			t.client = client
+			t.bus = client
+		} else {
+			if t.ReplayFile != "" && t.Transport == nil {
+				records, err := readCaptureFile(t.ReplayFile)
+				if err != nil {
+					return err
+				}
+				log.Printf("Replaying %v HID reports from %v", len(records), t.ReplayFile)
+				t.Transport = ft260.NewReplayTransport(records)
+			}
+			if t.CaptureFile != "" {
+				capture, err := os.Create(t.CaptureFile)
+				if err != nil {
+					return err
+				}
+				log.Printf("Recording HID reports to %v", t.CaptureFile)
+				t.capture = capture
+			}
+			if t.Transport != nil {
+				t.usb = ft260.NewFt260(t.Transport)
+			} else {
+				// Prepare Usb HID library, open FT260 device
+				if err := hid.Init(); err != nil {
+					return err
+				}
+				usb, err := ft260.OpenPath(t.UsbDevice)
+				if err != nil {
+					return err
+				}
+				t.usb = usb
+			}
+			t.captureFt260(t.usb)
+			t.usbLock = new(sync.RWMutex)
+			if t.NoRecovery {
+				t.bus = t.usb
+			} else {
+				t.bus = &recoveringI2cBus{tank: t}
+			}
 		}
-		usb, err := ft260.OpenPath(t.UsbDevice)
-		if err != nil {
-			return err
+		if t.WrapBus != nil {
+			t.bus = t.WrapBus(t.bus)
 		}
-		t.usb = usb
-		t.sequencer.usb = t.usb
+		if t.DecodeI2c {
+			t.bus = i2cdecode.NewBus(t.bus, t.I2cDecoder())
+		}
+		if t.CollectI2cStats {
+			t.bus = i2cstats.NewBus(t.bus, t.I2cStats())
+			t.sequencer.stats = t.I2cStats()
+		}
+		t.sequencer.bus = t.bus
 		t.Motors.bus = t.Bus()
 		t.Leds.bus = t.Bus()
 		t.Adc.bus = t.Bus()
 
-		// Configure and validate system settings
-		if err := t.validateFt260ChipCode(); err != nil {
-			return err
+		if t.usb != nil {
+			// Configure and validate system settings
+			if err := t.validateFt260ChipCode(); err != nil {
+				return err
+			}
+			if err := t.configureFt260(); err != nil {
+				return err
+			}
+			if err := t.validateFt260(); err != nil {
+				return err
+			}
 		}
-		if err := t.configureFt260(); err != nil {
-			return err
+		if t.OutputEnablePin != "" {
+			if _, err := t.outputEnable(t.Bus()); err != nil {
+				return err
+			}
 		}
-		if err := t.validateFt260(); err != nil {
-			return err
+		if t.ServeI2c != "" {
+			t.server = i2cbroker.NewServer(t.Bus())
+			// Other processes sharing the bus must not delay motor control
+			t.server.BaseContext = WithI2cPriority(context.Background(), I2cPriorityCosmetic)
+			if err := t.server.Listen(t.ServeI2c); err != nil {
+				return err
+			}
+			log.Printf("Sharing I2C bus through %v", t.ServeI2c)
 		}
 	}
 	return nil
@@ -134,6 +315,9 @@ func (t *Tank) InitI2cPeripherals() error {
 	if err := t.Adc.Init(); err != nil {
 		return err
 	}
+	if !t.SkipInit {
+		return t.reapplyOutputEnable()
+	}
 	return nil
 }
 
@@ -141,25 +325,185 @@ func (t *Tank) Bus() ft260.I2cBus {
 	if t.Dummy {
 		return new(dummyI2cBus)
 	} else if t.NoI2cSequencer {
-		return t.usb
+		return t.bus
 	} else {
 		return &t.sequencer
 	}
 }
 
+// Submit executes the request asynchronously through the I2C sequencer and returns it as handle for the result.
+// Without sequencer, the request is executed synchronously.
+func (t *Tank) Submit(req *I2cRequest) *I2cRequest {
+	if t.Dummy || t.NoI2cSequencer {
+		req.init()
+		if req.start() {
+			req.execute(t.Bus())
+		}
+		return req
+	}
+	return t.sequencer.Submit(req)
+}
+
+// Submits all requests and returns a handle for awaiting them together
+func (t *Tank) SubmitBatch(requests ...*I2cRequest) *I2cBatch {
+	for _, req := range requests {
+		t.Submit(req)
+	}
+	return newI2cBatch(requests)
+}
+
+// Returns the FT260 device used as I2C bus, or nil if the tank uses another bus implementation.
+// The device can be replaced when recovering from USB failures.
+func (t *Tank) Ft260() *ft260.Ft260 {
+	if t.usbLock == nil {
+		return nil
+	}
+	t.usbLock.RLock()
+	defer t.usbLock.RUnlock()
+	return t.usb
+}
+
+// Returns a channel receiving the interrupt events of the FT260, see EnableInterrupt. Unlike Ft260().Interrupts(),
+// the channel keeps delivering events after the device was reopened. Returns nil without FT260.
+func (t *Tank) Interrupts() <-chan ft260.InterruptEvent {
+	if t.usbLock == nil {
+		return nil
+	}
+	t.usbLock.Lock()
+	defer t.usbLock.Unlock()
+	if t.interrupts == nil {
+		t.interrupts = make(chan ft260.InterruptEvent, ft260.InterruptBufferSize)
+		go t.forwardInterrupts(t.usb.Interrupts())
+	}
+	return t.interrupts
+}
+
+// Runs until the interrupt channel of one FT260 device is closed
+func (t *Tank) forwardInterrupts(events <-chan ft260.InterruptEvent) {
+	for event := range events {
+		select {
+		case t.interrupts <- event:
+		default:
+			log.Warnln("Dropping FT260 interrupt event, channel buffer is full")
+		}
+	}
+}
+
+// Returns a decoder for the I2C traffic of the tank peripherals. Further devices can be attached to the decoder.
+func (t *Tank) I2cDecoder() *i2cdecode.Decoder {
+	if t.decoder == nil {
+		t.decoder = i2cdecode.NewDecoder()
+		t.decoder.Attach(t.Motors.I2cAddr, i2cdecode.NewPCA9685())
+		t.decoder.Attach(t.Leds.I2cAddr, i2cdecode.NewPCA9685())
+		t.decoder.Attach(t.Adc.I2cAddr, i2cdecode.NewADS1115())
+	}
+	return t.decoder
+}
+
+// Returns the statistics collected with CollectI2cStats
+func (t *Tank) I2cStats() *i2cstats.Stats {
+	if t.stats == nil {
+		t.stats = i2cstats.NewStats()
+	}
+	return t.stats
+}
+
+// Switches off all outputs of the motor and LED PWM drivers with one write to their all-call address.
+// Without a usable all-call address, or if the all-call write fails, the motors and LEDs are stopped separately.
+// The motors stay stopped until ClearEmergencyStop is called: MainMotors.Set only accepts zero speeds until then.
+// If OutputEnablePin is configured, the outputs are also disabled.
+func (t *Tank) EmergencyStop() error {
+	ctx := WithI2cPriority(context.Background(), I2cPrioritySafety)
+	allCall := t.PwmAllCallAddr != 0 && !t.Motors.Dummy && !t.Leds.Dummy
+
+	// No LED update is written between the all-call write and invalidating the LED state
+	t.Leds.writeLock.Lock()
+	err := t.Motors.emergencyStop(func() error {
+		if allCall {
+			allCallErr := pca9685.NewDevice(t.Bus(), t.PwmAllCallAddr).AllOffContext(ctx)
+			if allCallErr == nil {
+				return nil
+			}
+			// E.g. the devices do not respond to the all-call address
+			log.Warnf("Emergency stop through all-call address %#02x failed, stopping motors and LEDs separately: %v", t.PwmAllCallAddr, allCallErr)
+			allCall = false
+		}
+		return t.Motors.set(ctx, 0, 0)
+	})
+	if allCall {
+		// The LED outputs are fully rewritten with the next update
+		t.Leds.invalidateState()
+	}
+	t.Leds.writeLock.Unlock()
+	if !allCall {
+		if ledErr := t.Leds.DisableAll(); err == nil {
+			err = ledErr
+		}
+	}
+	if oeErr := t.SetPwmOutputsEnabled(false); err == nil {
+		err = oeErr
+	}
+	return err
+}
+
+// Releases the motors after EmergencyStop and enables the PWM outputs again
+func (t *Tank) ClearEmergencyStop() error {
+	t.Motors.ClearStop()
+	return t.SetPwmOutputsEnabled(true)
+}
+
 func (t *Tank) Cleanup() {
-	if err := t.Motors.Set(0, 0); err != nil {
+	if t.server != nil {
+		if err := t.server.Close(); err != nil {
+			log.Errorf("Cleanup: Failed to stop sharing the I2C bus: %v", err)
+		}
+	}
+	if t.client != nil {
+		if err := t.client.Close(); err != nil {
+			log.Errorf("Cleanup: Failed to close shared I2C bus: %v", err)
+		}
+		return
+	}
+	if err := t.Motors.Stop(); err != nil {
 		log.Errorf("Cleanup: Failed to disable motors: %v", err)
 	}
 	if err := t.Leds.DisableAll(); err != nil {
 		log.Errorf("Cleanup: Failed to disabled LEDs: %v", err)
 	}
-	if err := hid.Shutdown(); err != nil {
-		log.Errorf("Cleanup: Failed to stop USB HID device: %v", err)
+	if t.i2cDev != nil {
+		if err := t.i2cDev.Close(); err != nil {
+			log.Errorf("Cleanup: Failed to close I2C device: %v", err)
+		}
+		return
 	}
-	if err := t.usb.Close(); err != nil {
+	if t.Transport == nil {
+		if err := hid.Shutdown(); err != nil {
+			log.Errorf("Cleanup: Failed to stop USB HID device: %v", err)
+		}
+	}
+	if err := t.Ft260().Close(); err != nil {
 		log.Errorf("Cleanup: Failed to close USB connection: %v", err)
 	}
+	if t.capture != nil {
+		if err := t.capture.Close(); err != nil {
+			log.Errorf("Cleanup: Failed to close HID capture file: %v", err)
+		}
+	}
+}
+
+func (t *Tank) captureFt260(usb *ft260.Ft260) {
+	if t.capture != nil {
+		usb.Transport = ft260.NewCaptureTransport(usb.Transport, t.capture)
+	}
+}
+
+func readCaptureFile(filename string) ([]ft260.CaptureRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ft260.ReadCapture(file)
 }
 
 func (t *Tank) validateFt260ChipCode() error {
@@ -180,7 +524,10 @@ func (t *Tank) configureFt260() (err error) {
 	t.writeConfigValue(&err, ft260.SetSystemSetting_GPIO_2, ft260.GPIO_2_Normal) // Set all GPIO pins to normal operation
 	t.writeConfigValue(&err, ft260.SetSystemSetting_GPIO_A, ft260.GPIO_A_Normal)
 	t.writeConfigValue(&err, ft260.SetSystemSetting_GPIO_G, ft260.GPIO_G_Normal)
-	t.writeConfigValue(&err, ft260.SetSystemSetting_EnableWakeupInt, false)
+	t.writeConfigValue(&err, ft260.SetSystemSetting_EnableWakeupInt, t.EnableInterrupt)
+	if t.EnableInterrupt {
+		t.writeConfigValue(&err, ft260.SetSystemSetting_Interrupt, t.Interrupt)
+	}
 	return
 }
 
@@ -213,8 +560,16 @@ func (t *Tank) validateFt260() error {
 	if status.GPIOGFunction != ft260.GPIO_G_Normal {
 		return fmt.Errorf("FT260: unexpected GPIO G function %02x (expected %02x)", status.GPIOGFunction, ft260.GPIO_G_Normal)
 	}
-	if status.EnableWakeupInt {
-		return fmt.Errorf("FT260: unexpected clock value %v (expected %v)", status.EnableWakeupInt, false)
+	if status.EnableWakeupInt != t.EnableInterrupt {
+		return fmt.Errorf("FT260: unexpected wakeup interrupt setting %v (expected %v)", status.EnableWakeupInt, t.EnableInterrupt)
+	}
+	if t.EnableInterrupt {
+		if trigger := status.InterruptTriggerCondition(); trigger != t.Interrupt.Trigger {
+			return fmt.Errorf("FT260: unexpected interrupt trigger %v (expected %v)", trigger, t.Interrupt.Trigger)
+		}
+		if duration := status.InterruptLevelDuration(); t.Interrupt.IsLevelTrigger() && duration != t.Interrupt.LevelDuration {
+			return fmt.Errorf("FT260: unexpected interrupt level duration %v (expected %v)", duration, t.Interrupt.LevelDuration)
+		}
 	}
 	if status.Suspended {
 		return errors.New("FT260: device is suspended")
@@ -235,3 +590,60 @@ func (t *Tank) validateFt260() error {
 	}
 	return nil
 }
+
+// Registers flags for the MODE2 options of a PWM driver
+func registerOutputFlags(outputs *pca9685.OutputConfig, prefix string, name string) {
+	flag.BoolVar(&outputs.OpenDrain, prefix+"-open-drain", outputs.OpenDrain, "Use open drain outputs on the "+name+" PWM driver instead of totem pole")
+	flag.BoolVar(&outputs.Invert, prefix+"-invert", outputs.Invert, "Invert the outputs of the "+name+" PWM driver")
+	flag.BoolVar(&outputs.ChangeOnAck, prefix+"-change-on-ack", outputs.ChangeOnAck, "Change the outputs of the "+name+" PWM driver on ACK instead of STOP")
+	flag.Var(byteFlag{(*byte)(&outputs.Disabled)}, prefix+"-disabled-outputs", "State of the "+name+" PWM driver outputs while disabled through the OE pin (0: off, 1: on, 2: high impedance)")
+}
+
+// Implements flag.Value for byte-sized settings
+type byteFlag struct {
+	val *byte
+}
+
+func (f byteFlag) String() string {
+	if f.val == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*f.val))
+}
+
+func (f byteFlag) Set(s string) error {
+	val, err := strconv.ParseUint(s, 0, 8)
+	if err == nil {
+		*f.val = byte(val)
+	}
+	return err
+}
+
+// Implements flag.Value for comma-separated lists of floats
+type floatsFlag struct {
+	val *[]float64
+}
+
+func (f floatsFlag) String() string {
+	if f.val == nil {
+		return ""
+	}
+	parts := make([]string, len(*f.val))
+	for i, v := range *f.val {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f floatsFlag) Set(s string) error {
+	var result []float64
+	for _, part := range strings.Split(s, ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return err
+		}
+		result = append(result, val)
+	}
+	*f.val = result
+	return nil
+}
@@ -0,0 +1,607 @@
+package tank
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antongulenko/tank/ads1115"
+	"github.com/antongulenko/tank/ft260"
+	"github.com/antongulenko/tank/i2cfault"
+	"github.com/antongulenko/tank/mcp23017"
+	"github.com/antongulenko/tank/pca9685"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the PWM drivers of motors and LEDs by address
+func newSimulatedTank() (*Tank, *ft260.Simulator, map[byte]*pca9685.Model) {
+	sim := ft260.NewSimulator()
+	slaves := make(map[byte]*pca9685.Model)
+	tank := DefaultTank
+	tank.Transport = sim
+	tank.ReopenTransport = func() (ft260.Transport, error) {
+		return sim, nil
+	}
+	allCall := &pca9685.Broadcast{Addr: tank.PwmAllCallAddr}
+	for _, addr := range []byte{tank.Motors.I2cAddr, tank.Leds.I2cAddr} {
+		slave := pca9685.NewModel()
+		slaves[addr] = slave
+		sim.Attach(addr, slave)
+		allCall.Models = append(allCall.Models, slave)
+	}
+	sim.Attach(allCall.Addr, allCall)
+	sim.Attach(tank.Adc.I2cAddr, ads1115.NewModel(nil))
+	return &tank, sim, slaves
+}
+
+func TestSimulatedSetup(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, slaves := newSimulatedTank()
+	sim.Status.Clock = ft260.Clock12MHz
+	sim.Status.EnableWakeupInt = true
+
+	a.NoError(tank.Setup())
+	a.Equal(ft260.Clock48MHz, sim.Status.Clock)
+	a.Equal(uint16(tank.I2cFreq), sim.I2cStatus.BusSpeed)
+
+	a.NoError(tank.InitI2cPeripherals())
+	motors := slaves[tank.Motors.I2cAddr]
+	a.Equal(pca9685.MODE1_ALLCALL|pca9685.MODE1_AI, motors.Registers[pca9685.MODE1])
+	a.InDelta(tank.Motors.Frequency, motors.Frequency(), 20)
+	a.InDelta(200, slaves[tank.Leds.I2cAddr].Frequency(), 5)
+
+	a.NoError(tank.Motors.Set(100, 0))
+	a.Equal(pca9685.TIMER_MAX, int(motors.Registers[pca9685.LED1_OFF_L])+int(motors.Registers[pca9685.LED1_OFF_H])<<8)
+}
+
+func TestSimulatedBattery(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, _ := newSimulatedTank()
+	voltage := 3.0
+	sim.Attach(tank.Adc.I2cAddr, ads1115.NewModel(func(mux uint16) float64 {
+		if mux != ads1115.CONFIG_MUX_03 {
+			return 0
+		}
+		return voltage
+	}))
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+
+	percentage, err := tank.Adc.GetBatteryPercentage()
+	a.NoError(err)
+	a.InDelta(0.625, percentage, 0.001)
+	voltage = 2
+	percentage, err = tank.Adc.GetBatteryPercentage()
+	a.NoError(err)
+	a.Equal(0.0, percentage)
+}
+
+func TestSimulatedDutyCycles(t *testing.T) {
+	a := assert.New(t)
+	tank, _, slaves := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+
+	// Left direction, left speed, right direction, right speed
+	motors := slaves[tank.Motors.I2cAddr]
+	a.NoError(tank.Motors.Set(50, -25))
+	a.InDeltaSlice([]float64{1, 0.5, 0, 0.25}, motors.Duties()[:4], 0.001)
+
+	// LED values are gamma corrected and scaled into 0..0.7
+	leds := slaves[tank.Leds.I2cAddr]
+	half := 0.7 * math.Pow(0.5, 2.2)
+	a.NoError(tank.Leds.SetRow(0, 3, 0.625))
+	a.InDeltaSlice([]float64{0.7, 0.7, half, 0, 0}, leds.Duties()[:5], 0.001)
+	a.Equal(0.0, leds.Duty(15))
+
+	tank.Leds.PwmOutput.Gamma = 0
+	tank.Leds.PwmOutput.StaggerPhases = true
+	a.NoError(tank.Leds.SetAll([]float64{0.5, 0.25, 0.75}))
+	a.InDeltaSlice([]float64{0, 1.0 / 15, 2.0 / 15}, []float64{leds.Delay(0), leds.Delay(1), leds.Delay(2)}, 0.001)
+	a.InDeltaSlice([]float64{0.35, 0.175, 0.525}, leds.Duties()[:3], 0.001)
+}
+
+func TestLedCalibration(t *testing.T) {
+	a := assert.New(t)
+	tank, _, slaves := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	leds := slaves[tank.Leds.I2cAddr]
+
+	red, green, _ := tank.Leds.Groups()
+	red.Calibrate(0.5, 0)
+	green.Calibrate(1, 0.1)
+	a.NoError(tank.Leds.SetAll([]float64{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0}))
+	a.InDelta(0.35, leds.Duty(5), 0.001)
+	a.InDelta(0.7, leds.Duty(10), 0.001)
+	a.NoError(tank.Leds.SetAll([]float64{0, 0, 0, 0, 0, 0.5, 0, 0, 0, 0, 0.5, 0}))
+	a.InDelta(0.35*math.Pow(0.5, 2.2), leds.Duty(5), 0.001)
+	a.InDelta(0.7*(0.1+math.Pow(0.5, 2.2)), leds.Duty(10), 0.001)
+	a.Equal(0.0, leds.Duty(11))
+}
+
+func TestSimulatedServo(t *testing.T) {
+	a := assert.New(t)
+	tank, _, slaves := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+
+	_, err := tank.Leds.Servo(3)
+	a.Error(err)
+	servo, err := tank.Leds.Servo(15)
+	a.NoError(err)
+	a.NoError(servo.SetAngle(180))
+	a.InDelta(0.4, slaves[tank.Leds.I2cAddr].Duty(15), 0.01) // 2ms pulses at 200 Hz
+
+	// The servo output is not touched by LED updates
+	a.NoError(tank.Leds.DisableAll())
+	a.InDelta(0.4, slaves[tank.Leds.I2cAddr].Duty(15), 0.01)
+}
+
+func TestEmergencyStop(t *testing.T) {
+	a := assert.New(t)
+	tank, _, slaves := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	motors, leds := slaves[tank.Motors.I2cAddr], slaves[tank.Leds.I2cAddr]
+
+	a.NoError(tank.Motors.Set(50, 50))
+	a.NoError(tank.Leds.SetRow(0, 14, 1))
+	a.NoError(tank.EmergencyStop())
+	a.Equal(make([]float64, pca9685.NUM_OUTPUTS), motors.Duties())
+	a.Equal(make([]float64, pca9685.NUM_OUTPUTS), leds.Duties())
+
+	// The motors stay stopped until the emergency stop is cleared
+	a.True(tank.Motors.Stopped())
+	a.Equal(ErrMotorsStopped, tank.Motors.Set(50, 50))
+	a.NoError(tank.Motors.Set(0, 0))
+	a.Equal(make([]float64, pca9685.NUM_OUTPUTS), motors.Duties())
+
+	// The previous values are written again
+	a.NoError(tank.ClearEmergencyStop())
+	a.False(tank.Motors.Stopped())
+	a.NoError(tank.Motors.Set(50, 50))
+	a.InDeltaSlice([]float64{1, 0.5, 1, 0.5}, motors.Duties()[:4], 0.001)
+}
+
+func TestEmergencyStopWithoutAllCall(t *testing.T) {
+	a := assert.New(t)
+	tank, _, slaves := newSimulatedTank()
+	tank.PwmAllCallAddr = 0
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	motors := slaves[tank.Motors.I2cAddr]
+
+	a.NoError(tank.Motors.Set(50, 50))
+	a.NoError(tank.EmergencyStop())
+	a.Equal(make([]float64, pca9685.NUM_OUTPUTS), motors.Duties())
+	a.Equal(ErrMotorsStopped, tank.Motors.Set(50, 50))
+	a.NoError(tank.ClearEmergencyStop())
+	a.NoError(tank.Motors.Set(50, 50))
+	a.InDeltaSlice([]float64{1, 0.5, 1, 0.5}, motors.Duties()[:4], 0.001)
+}
+
+func TestLedUpdateOrder(t *testing.T) {
+	a := assert.New(t)
+	tank, _, slaves := newSimulatedTank()
+	delaying := &delayingI2cBus{started: make(chan struct{})}
+	tank.WrapBus = func(bus ft260.I2cBus) ft260.I2cBus {
+		delaying.I2cBusContext = bus.(ft260.I2cBusContext)
+		return delaying
+	}
+	tank.NoI2cSequencer = true
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	leds := slaves[tank.Leds.I2cAddr]
+	lastLed := byte(tank.Leds.NumLeds - 1)
+
+	// The second update is written after the delayed first update, so the assumed state matches the device
+	delaying.delayNext(tank.Leds.I2cAddr, 20*time.Millisecond)
+	done := make(chan error)
+	go func() {
+		done <- tank.Leds.SetRow(0, lastLed, 1)
+	}()
+	<-delaying.started
+	a.NoError(tank.Leds.SetRow(0, lastLed, 0))
+	a.NoError(<-done)
+	a.NoError(tank.Leds.SetRow(0, lastLed, 0))
+	a.Equal(make([]float64, pca9685.NUM_OUTPUTS), leds.Duties())
+}
+
+// Delays one write to the given address, to let other writes overtake it
+type delayingI2cBus struct {
+	ft260.I2cBusContext
+	lock    sync.Mutex
+	addr    byte
+	delay   time.Duration
+	started chan struct{} // Closed when the delayed write starts
+}
+
+func (b *delayingI2cBus) delayNext(addr byte, delay time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.addr, b.delay = addr, delay
+}
+
+func (b *delayingI2cBus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	b.lock.Lock()
+	delay := time.Duration(0)
+	if addr == b.addr && b.delay > 0 {
+		delay, b.delay = b.delay, 0
+		close(b.started)
+	}
+	b.lock.Unlock()
+	time.Sleep(delay)
+	return b.I2cBusContext.I2cWriteContext(ctx, addr, data...)
+}
+
+func TestEmergencyStopAllCallFails(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, slaves := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	motors, leds := slaves[tank.Motors.I2cAddr], slaves[tank.Leds.I2cAddr]
+
+	// The devices do not respond to the all-call address, so the motors and LEDs are stopped separately
+	sim.Detach(tank.PwmAllCallAddr)
+	a.NoError(tank.Motors.Set(50, 50))
+	a.NoError(tank.Leds.SetRow(0, 14, 1))
+	a.NoError(tank.EmergencyStop())
+	a.True(tank.Motors.Stopped())
+	a.Equal(make([]float64, pca9685.NUM_OUTPUTS), motors.Duties())
+	a.Equal(make([]float64, pca9685.NUM_OUTPUTS), leds.Duties())
+}
+
+func TestOutputEnable(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, slaves := newSimulatedTank()
+	gpio := mcp23017.NewModel()
+	sim.Attach(mcp23017.ADDRESS, gpio)
+	tank.OutputEnablePin = "B7"
+	tank.Leds.Outputs = pca9685.OutputConfig{OpenDrain: true, Invert: true}
+	priorities := &priorityRecordingBus{addr: mcp23017.ADDRESS}
+	tank.WrapBus = func(bus ft260.I2cBus) ft260.I2cBus {
+		priorities.I2cBusContext = bus.(ft260.I2cBusContext)
+		return priorities
+	}
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	a.Equal(pca9685.MODE2_INVRT, slaves[tank.Leds.I2cAddr].Registers[pca9685.MODE2])
+	a.Equal(pca9685.MODE2_OUTDRV, slaves[tank.Motors.I2cAddr].Registers[pca9685.MODE2])
+
+	// OE is active-low, the other pins stay inputs
+	a.Equal(byte(0x7F), gpio.Registers[1][0])
+	a.Equal(byte(0x00), gpio.Pins(1)&0x80)
+	priorities.priorities = nil
+	a.NoError(tank.EmergencyStop())
+	a.Equal(byte(0x80), gpio.Pins(1)&0x80)
+	a.Equal([]I2cPriority{I2cPrioritySafety, I2cPrioritySafety}, priorities.priorities)
+	a.NoError(tank.ClearEmergencyStop())
+	a.Equal(byte(0x00), gpio.Pins(1)&0x80)
+}
+
+func TestOutputEnableRecovery(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, _ := newSimulatedTank()
+	sim.Attach(mcp23017.ADDRESS, mcp23017.NewModel())
+	tank.OutputEnablePin = "A0"
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+
+	// The OE pin is restored asynchronously after recovering, so it is read through the sequencer
+	oePin := func() byte {
+		data, err := tank.Bus().I2cGet(mcp23017.ADDRESS, mcp23017.GPIO_A_PAIRED, 1)
+		a.NoError(err)
+		return data[0] & 0x01
+	}
+
+	// The FT260 is reopened while the OE pin is written, which must not deadlock
+	sim.FailReports = 2
+	a.NoError(tank.SetPwmOutputsEnabled(false))
+	a.Equal(byte(0x01), oePin())
+	a.NoError(tank.SetPwmOutputsEnabled(true))
+	a.Equal(byte(0x00), oePin())
+}
+
+// Records the I2C priority of the writes to one address
+type priorityRecordingBus struct {
+	ft260.I2cBusContext
+	addr       byte
+	priorities []I2cPriority
+}
+
+func (b *priorityRecordingBus) I2cWriteContext(ctx context.Context, addr byte, data ...byte) error {
+	if addr == b.addr {
+		b.priorities = append(b.priorities, I2cPriorityFromContext(ctx))
+	}
+	return b.I2cBusContext.I2cWriteContext(ctx, addr, data...)
+}
+
+func TestOutputEnableMcp23017Bank(t *testing.T) {
+	a := assert.New(t)
+	sim := ft260.NewSimulator()
+	gpio := mcp23017.NewModel()
+	sim.Attach(mcp23017.ADDRESS, gpio)
+	bus := ft260.NewFt260(sim)
+	a.NoError(bus.I2cWrite(mcp23017.ADDRESS, mcp23017.IOCON_PAIRED, mcp23017.IOCON_BIT_BANK))
+
+	oe := &Mcp23017OutputEnable{Bus: bus, Addr: mcp23017.ADDRESS, Port: 1, Pin: 2, Bank: true}
+	a.NoError(oe.SetOutputsEnabled(false))
+	a.Equal(byte(0xFB), gpio.Registers[1][0])
+	a.Equal(byte(0x04), gpio.Pins(1))
+	a.NoError(oe.SetOutputsEnabled(true))
+	a.Equal(byte(0x00), gpio.Pins(1))
+	a.Equal(mcp23017.INPUT, gpio.Registers[0][0])
+
+	// The latch is not modified when reading it fails
+	sim.Detach(mcp23017.ADDRESS)
+	err := oe.SetOutputsEnabled(false)
+	var txErr *I2cTransactionError
+	if a.True(errors.As(err, &txErr)) {
+		a.Equal(0, txErr.Step)
+	}
+}
+
+func TestOutputEnableFt260(t *testing.T) {
+	a := assert.New(t)
+	tank, _, _ := newSimulatedTank()
+	tank.OutputEnablePin = "GPIOA"
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	high, err := tank.Ft260().GpioRead(ft260.GPIOA)
+	a.NoError(err)
+	a.False(high)
+	a.NoError(tank.EmergencyStop())
+	high, err = tank.Ft260().GpioRead(ft260.GPIOA)
+	a.NoError(err)
+	a.True(high)
+
+	tank.OutputEnablePin = "C9"
+	a.Error(tank.SetPwmOutputsEnabled(true))
+}
+
+func TestSmoothTankCleanup(t *testing.T) {
+	a := assert.New(t)
+	simTank, _, slaves := newSimulatedTank()
+	smooth := &SmoothTank{
+		Tank:           *simTank,
+		SleepTime:      time.Millisecond,
+		AccelSlopeTime: time.Second,
+	}
+	a.NoError(smooth.Setup())
+	motors := slaves[smooth.Motors.I2cAddr]
+
+	smooth.Left().SetSpeed(1)
+	smooth.Right().SetSpeed(-1)
+	time.Sleep(20 * time.Millisecond)
+
+	// The adjust loop is stopped before the motors, so no motor update is executed afterwards
+	smooth.Cleanup()
+	time.Sleep(20 * time.Millisecond)
+	a.Zero(motors.Duty(1))
+	a.Zero(motors.Duty(3))
+}
+
+func TestSmoothTankEmergencyStop(t *testing.T) {
+	a := assert.New(t)
+	simTank, _, slaves := newSimulatedTank()
+	smooth := &SmoothTank{
+		Tank:      *simTank,
+		SleepTime: time.Millisecond,
+	}
+	a.NoError(smooth.Setup())
+	defer smooth.Cleanup()
+	motors := slaves[smooth.Motors.I2cAddr]
+
+	smooth.Left().SetSpeed(1)
+	time.Sleep(20 * time.Millisecond)
+	a.NoError(smooth.EmergencyStop())
+	a.Zero(smooth.Left().GetSpeed())
+
+	// New targets are discarded until the emergency stop is cleared
+	smooth.Left().SetSpeed(1)
+	time.Sleep(20 * time.Millisecond)
+	a.Zero(smooth.Left().GetSpeed())
+	a.Zero(motors.Duty(1))
+
+	a.NoError(smooth.ClearEmergencyStop())
+	smooth.Left().SetSpeed(1)
+	time.Sleep(20 * time.Millisecond)
+	a.InDelta(1, motors.Duty(1), 0.001)
+}
+
+func TestSimulatedSetupWrongChip(t *testing.T) {
+	tank, sim, _ := newSimulatedTank()
+	sim.ChipCode = 0x01020304
+	assert.Error(t, tank.Setup())
+}
+
+func TestRecoverStuckBus(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, slaves := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+
+	sim.BusStuck = true
+	a.NoError(tank.Motors.Set(50, 50))
+	a.False(sim.BusStuck)
+
+	// The outputs might be half-written after resetting the I2C controller, so they are written again
+	motors := slaves[tank.Motors.I2cAddr]
+	motors.Registers[pca9685.LED1_OFF_H] = 0
+	a.NoError(tank.Motors.Set(50, 50))
+	a.Equal(byte(0x07), motors.Registers[pca9685.LED1_OFF_H])
+}
+
+func TestRecoverInjectedTransport(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, _ := newSimulatedTank()
+	tank.ReopenTransport = nil
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	usb := tank.Ft260()
+
+	// Without ReopenTransport, the transport error is returned after the I2C reset fails
+	sim.FailReports = 2
+	err := tank.Motors.Set(100, 0)
+	var transportErr *ft260.TransportError
+	a.True(errors.As(err, &transportErr), "Unexpected error: %v", err)
+	a.True(usb == tank.Ft260())
+	a.NoError(tank.Motors.Set(100, 0))
+}
+
+func TestRecoverReopen(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, slaves := newSimulatedTank()
+	tank.EnableInterrupt = true
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	motors := slaves[tank.Motors.I2cAddr]
+	usb := tank.Ft260()
+	interrupts := tank.Interrupts()
+
+	// The I2C operation and the I2C reset fail, so the device is reopened and the peripherals are reinitialized
+	motors.Registers[pca9685.MODE1] = 0
+	sim.FailReports = 2
+	a.NoError(tank.Motors.Set(100, 0))
+	a.True(usb != tank.Ft260())
+	a.Equal(pca9685.MODE1_ALLCALL|pca9685.MODE1_AI, motors.Registers[pca9685.MODE1])
+	a.Equal(pca9685.TIMER_MAX, int(motors.Registers[pca9685.LED1_OFF_L])+int(motors.Registers[pca9685.LED1_OFF_H])<<8)
+
+	// Interrupt events of the new device are still delivered
+	sim.TriggerInterrupt()
+	select {
+	case event := <-interrupts:
+		a.True(event.Interrupt)
+	case <-time.After(time.Second):
+		a.Fail("No interrupt event received after reopening the FT260")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(errorFatal, classifyError(&ft260.TransportError{Operation: "reading", Err: errors.New("broken")}))
+	a.Equal(errorBus, classifyError(&ft260.ShortReadError{ReportID: 0xC0, Expected: 5}))
+	a.Equal(errorBus, classifyError(&ft260.I2cError{TimedOut: true}))
+	a.Equal(errorBus, classifyError(&ft260.I2cError{BusStatus: ft260.I2C_StatusBusBusy}))
+	a.Equal(errorOther, classifyError(&ft260.I2cError{BusStatus: ft260.I2C_StatusError | ft260.I2C_StatusNoSlaveAck}))
+	a.Equal(errorOther, classifyError(errors.New("other")))
+}
+
+func TestRecoverResync(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, slaves := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	motors := slaves[tank.Motors.I2cAddr]
+	a.NoError(tank.Motors.Set(50, 0))
+
+	// The motor driver lost its state while the FT260 was reopened, the outputs are read back after reinitializing it
+	motors.Reset()
+	sim.FailReports = 2
+	a.NoError(tank.Motors.Set(100, 0))
+	a.NoError(tank.Motors.Set(100, 0))
+	a.InDelta(1, motors.Duty(0), 0.001)
+	a.InDelta(1, motors.Duty(1), 0.001)
+}
+
+func TestRecoveryGivesUp(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, _ := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+
+	sim.FailReports = 1000
+	a.Error(tank.Motors.Set(100, 0))
+	a.NotZero(sim.FailReports)
+
+	tank2, sim2, _ := newSimulatedTank()
+	tank2.NoRecovery = true
+	a.NoError(tank2.Setup())
+	sim2.BusStuck = true
+	a.Error(tank2.Motors.Set(100, 0))
+	a.True(sim2.BusStuck)
+}
+
+func TestI2cContext(t *testing.T) {
+	a := assert.New(t)
+	tank, sim, _ := newSimulatedTank()
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+	defer func(checks int) { ft260.I2cNumChecks = checks }(ft260.I2cNumChecks)
+	ft260.I2cNumChecks = 1000000
+
+	// A slow ADC read is aborted after the read timeout, and does not block subsequent operations
+	sim.I2cDelay = time.Second
+	tank.Adc.ReadTimeout = 20 * time.Millisecond
+	start := time.Now()
+	_, err := tank.Adc.GetBatteryVoltage()
+	a.True(errors.Is(err, context.DeadlineExceeded), "Unexpected error: %v", err)
+	a.True(time.Since(start) < 300*time.Millisecond)
+	sim.I2cDelay = 0
+	a.NoError(tank.Motors.Set(50, 50))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bus, ok := tank.Bus().(ft260.I2cBusContext)
+	if a.True(ok) {
+		a.Equal(context.Canceled, bus.I2cWriteContext(ctx, tank.Motors.I2cAddr, 0, 0))
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	a := assert.New(t)
+	tank, _, slaves := newSimulatedTank()
+	var faults *i2cfault.Bus
+	tank.WrapBus = func(bus ft260.I2cBus) ft260.I2cBus {
+		faults = i2cfault.NewBus(bus, 1)
+		return faults
+	}
+	a.NoError(tank.Setup())
+	a.NoError(tank.InitI2cPeripherals())
+
+	faults.Script(tank.Adc.I2cAddr, i2cfault.FaultNoSlaveAck)
+	_, err := tank.Adc.GetBatteryVoltage()
+	var i2cErr *ft260.I2cError
+	a.True(errors.As(err, &i2cErr), "Unexpected error: %v", err)
+	_, err = tank.Adc.GetBatteryVoltage()
+	a.NoError(err)
+
+	// After a failed update, all motor values are written again
+	a.NoError(tank.Motors.Set(50, 50))
+	faults.Script(tank.Motors.I2cAddr, i2cfault.FaultNoDataAck)
+	a.Error(tank.Motors.Set(50, 0))
+	slaves[tank.Motors.I2cAddr].Registers[pca9685.LED1_OFF_H] = 0
+	a.NoError(tank.Motors.Set(50, 0))
+	a.Equal(byte(0x07), slaves[tank.Motors.I2cAddr].Registers[pca9685.LED1_OFF_H])
+}
+
+func TestSharedBus(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "tank")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	owner, _, slaves := newSimulatedTank()
+	owner.ServeI2c = filepath.Join(dir, "i2c.sock")
+	a.NoError(owner.Setup())
+	a.NoError(owner.InitI2cPeripherals())
+	defer owner.Cleanup()
+	a.Equal(I2cPriorityCosmetic, I2cPriorityFromContext(owner.server.BaseContext))
+
+	client := DefaultTank
+	client.ConnectI2c = owner.ServeI2c
+	client.SkipInit = true
+	a.NoError(client.Setup())
+	a.Nil(client.Ft260())
+	a.NoError(client.Motors.Set(0, 50))
+	a.Equal(byte(0x07), slaves[owner.Motors.I2cAddr].Registers[pca9685.LED3_OFF_H])
+	client.Cleanup()
+	a.Equal(byte(0x07), slaves[owner.Motors.I2cAddr].Registers[pca9685.LED3_OFF_H])
+}